@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/config"
+	"sort"
+	"strings"
+)
+
+// runDiff implements `simple-config-server diff -product P -from E1 -to E2`,
+// the command line equivalent of GET /{product}/diff. It reads the configured
+// source directly and exits non-zero when the environments differ.
+func runDiff(args []string) int {
+	fs := newSubcommand("diff")
+	product := fs.String("product", "", "Product to compare; tenant/product in multi-tenant mode")
+	from := fs.String("from", "", "Environment to compare from")
+	to := fs.String("to", "", "Environment to compare to")
+	raw := fs.Bool("raw", false, "Compare values as written instead of resolved")
+	asJSON := fs.Bool("json", false, "Print the diff as JSON")
+	fs.Parse(args)
+
+	if *product == "" || *from == "" || *to == "" {
+		fmt.Fprintln(os.Stderr, "usage: diff -product PRODUCT -from ENV -to ENV [-raw] [-json]")
+		return 2
+	}
+
+	allowEnvPlaceholders()
+	store, err := config.ReadStore(getSource(getConfigDir()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read configs: %v\n", err)
+		return 2
+	}
+
+	diff, err := config.DiffEnvironments(store, *product, *from, *to, *raw)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(diff)
+	} else {
+		printDiff(diff)
+	}
+
+	if diff.Empty() {
+		return 0
+	}
+	return 1
+}
+
+// runPromote implements `simple-config-server promote -product P -from E1 -to
+// E2 [-keys a,b]`, which prints the promotion plan, and the same command with
+// `-apply PLAN_ID`, which applies it to the configured source like
+// POST /{product}/promote.
+func runPromote(args []string) int {
+	fs := newSubcommand("promote")
+	product := fs.String("product", "", "Product to promote; tenant/product in multi-tenant mode")
+	from := fs.String("from", "", "Environment to promote from")
+	to := fs.String("to", "", "Environment to promote to")
+	keyList := fs.String("keys", "", "Comma separated keys to promote; every key when empty")
+	apply := fs.String("apply", "", "ID of the plan to apply")
+	user := fs.String("user", os.Getenv("USER"), "User recorded as having made the promotion")
+	fs.Parse(args)
+
+	if *product == "" || *from == "" || *to == "" {
+		fmt.Fprintln(os.Stderr, "usage: promote -product PRODUCT -from ENV -to ENV [-keys KEY,...] [-apply PLAN_ID]")
+		return 2
+	}
+	if *user == "" {
+		*user = "SYSTEM"
+	}
+	var keys []string
+	if *keyList != "" {
+		keys = strings.Split(*keyList, ",")
+	}
+
+	allowEnvPlaceholders()
+	source := getSource(getConfigDir())
+
+	// Neither planning nor applying loads the configs, so nothing is
+	// audited as a load
+	if *apply == "" {
+		store, err := config.ReadStore(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read configs: %v\n", err)
+			return 2
+		}
+		plan, err := config.PlanPromotion(store, *product, *from, *to, keys)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		printPlan(plan)
+		if !plan.Empty() {
+			fmt.Printf("\nApply with: promote -product %s -from %s -to %s", *product, *from, *to)
+			if *keyList != "" {
+				fmt.Printf(" -keys %s", *keyList)
+			}
+			fmt.Printf(" -apply %s\n", plan.ID)
+		}
+		return 0
+	}
+
+	plan, err := config.ApplyPromotion(source, *product, *from, *to, keys, *apply, config.Change{UserID: *user})
+	if errors.Is(err, config.ErrPlanStale) {
+		fmt.Fprintln(os.Stderr, "Refusing to promote:", err)
+		printPlan(plan)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, key := range plan.ChangedKeys() {
+		audit.LogConfigChange("CLI", "PROMOTED", *product, *to, key, plan.Changes[key].From, plan.Changes[key].To, *user)
+	}
+	fmt.Printf("Promoted %d keys from %s/%s to %s/%s\n", len(plan.Changes), *product, *from, *product, *to)
+	return 0
+}
+
+// newSubcommand returns a flag set for a subcommand that also accepts every
+// server flag, e.g. -config-dir or -source.
+func newSubcommand(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+// printPlan writes one line per key the plan sets.
+func printPlan(plan *config.Plan) {
+	fmt.Printf("Plan %s: %s/%s -> %s/%s\n", plan.ID, plan.Product, plan.From, plan.Product, plan.To)
+	if plan.Empty() {
+		fmt.Println("No changes")
+	}
+	for _, key := range plan.ChangedKeys() {
+		change := plan.Changes[key]
+		fmt.Printf("~ %s: %s -> %s\n", key, change.From, change.To)
+	}
+}
+
+// printDiff writes one line per key: + added, - removed, ~ changed.
+func printDiff(diff *config.Diff) {
+	fmt.Printf("--- %s/%s\n+++ %s/%s\n", diff.Product, diff.From, diff.Product, diff.To)
+	for _, key := range sortedKeys(diff.Added) {
+		fmt.Printf("+ %s=%s\n", key, diff.Added[key])
+	}
+	for _, key := range sortedKeys(diff.Removed) {
+		fmt.Printf("- %s=%s\n", key, diff.Removed[key])
+	}
+	changed := make([]string, 0, len(diff.Changed))
+	for key := range diff.Changed {
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+	for _, key := range changed {
+		fmt.Printf("~ %s: %s -> %s\n", key, diff.Changed[key].From, diff.Changed[key].To)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
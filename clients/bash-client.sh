@@ -4,7 +4,7 @@
 JWT_SECRET="${JWT_SECRET:-test}"
 
 # Constants
-BASE_URL="http://127.0.0.1:8080"
+BASE_URL="http://127.0.0.1:8080/v1"
 PRODUCT="sample"
 ENV="development"
 CONFIG_KEY="version"
@@ -28,18 +28,18 @@ generate_jwt() {
     echo "$data.$signature"
 }
 
-# Fetch config using the JWT token
+# Fetch config using the JWT token. With ?raw=true the server answers with the
+# bare value, so no JSON parsing is needed.
 fetch_config() {
     token=$(generate_jwt)
-    url="$BASE_URL/$PRODUCT/$ENV/$CONFIG_KEY"
+    url="$BASE_URL/$PRODUCT/$ENV/$CONFIG_KEY?raw=true"
 
-    response=$(curl -s -w "%{http_code}" -X GET "$url" -H "Authorization: Bearer $token" -H "Content-Type: application/json")
+    response=$(curl -s -w "%{http_code}" -X GET "$url" -H "Authorization: Bearer $token")
 
     response_code=$(echo "$response" | tail -c 4)
 
     if [[ "$response_code" == "200" ]]; then
-        echo "Config Data:"
-        echo "${response:0:${#response}-3}"
+        echo "$CONFIG_KEY=${response:0:${#response}-4}"
     else
         echo "Error: HTTP $response"
     fi
@@ -22,7 +22,7 @@ var JWT_SECRET = func() string {
 	return secret
 }()
 
-const BASE_URL = "http://127.0.0.1:8080"
+const BASE_URL = "http://127.0.0.1:8080/v1"
 const PRODUCT = "sample"
 const ENV = "development"
 const CONFIG_KEY = "version"
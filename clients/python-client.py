@@ -39,7 +39,7 @@ def generate_jwt():
 def fetch_config():
     """Sends an HTTP GET request to fetch config data."""
     token = generate_jwt()
-    url = f"/{PRODUCT}/{ENV}/{CONFIG_KEY}"
+    url = f"/v1/{PRODUCT}/{ENV}/{CONFIG_KEY}"
 
     conn = http.client.HTTPConnection(BASE_URL)
 
@@ -0,0 +1,199 @@
+package approval
+
+import (
+	"errors"
+	"time"
+
+	"simpleConfigServer/internal/changestore"
+)
+
+// ApproverRole is the role a token must grant to approve or reject change
+// requests.
+const ApproverRole = "approver"
+
+// ProtectedEnvironment is the environment that is only written once a
+// second principal with the approver role signed off, whichever way the
+// change is made.
+const ProtectedEnvironment = "production"
+
+// DefaultTTL is how long a change request stays pending before it expires.
+const DefaultTTL = 24 * time.Hour
+
+const (
+	StatusPending  = "PENDING"
+	StatusApplied  = "APPLIED"
+	StatusRejected = "REJECTED"
+	StatusExpired  = "EXPIRED"
+)
+
+var (
+	ErrNotFound     = errors.New("change request not found")
+	ErrNotPending   = errors.New("change request is no longer pending")
+	ErrSelfApproval = errors.New("change requests must be approved by someone other than their author")
+)
+
+// Request is a proposed change to one product environment: keys to set and
+// keys to remove. It is applied only once another principal approves it.
+type Request struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Product     string            `json:"product"`
+	Environment string            `json:"environment"`
+	Set         map[string]string `json:"set,omitempty"`
+	Remove      []string          `json:"remove,omitempty"`
+	Comment     string            `json:"comment,omitempty"`
+	Status      string            `json:"status"`
+	ProposedBy  string            `json:"proposed_by"`
+	ProposedAt  time.Time         `json:"proposed_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	ReviewedBy  string            `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time        `json:"reviewed_at,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+}
+
+// Keys lists the keys the request sets or removes, in name order.
+func (r *Request) Keys() []string {
+	return changestore.Keys(r.Set, r.Remove)
+}
+
+// Store keeps change requests in a JSON file, see changestore.
+type Store struct {
+	ttl      time.Duration
+	requests *changestore.Store[Request]
+}
+
+var defaultStore = &Store{ttl: DefaultTTL, requests: changestore.New(requestID, proposedBefore)}
+
+func requestID(r *Request) string {
+	return r.ID
+}
+
+func proposedBefore(a, b *Request) bool {
+	return a.ProposedAt.Before(b.ProposedAt)
+}
+
+// Default returns the store used by the handlers. It keeps requests in
+// memory only until Load is called.
+func Default() *Store {
+	return defaultStore
+}
+
+// Load makes the default store persist to path, reading the requests saved
+// there, and sets how long new requests stay pending.
+func Load(path string, ttl time.Duration) error {
+	store, err := Open(path, ttl)
+	if err != nil {
+		return err
+	}
+	defaultStore = store
+	return nil
+}
+
+// Open reads the change requests saved in path. A missing file is an empty
+// store.
+func Open(path string, ttl time.Duration) (*Store, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	requests, err := changestore.Open(path, requestID, proposedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{ttl: ttl, requests: requests}, nil
+}
+
+// Propose stores a new pending request. Its ID, status and timestamps are
+// set by the store.
+func (s *Store) Propose(r Request) (*Request, error) {
+	id, err := changestore.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	r.ID = id
+	r.Status = StatusPending
+	r.ProposedAt = now
+	r.ExpiresAt = now.Add(s.ttl)
+	r.ReviewedBy, r.ReviewedAt, r.Reason = "", nil, ""
+	return s.requests.Add(r)
+}
+
+// Get returns a copy of a request.
+func (s *Store) Get(id string) (*Request, bool) {
+	return s.requests.Get(id)
+}
+
+// List returns copies of the requests of a tenant's product, newest first.
+// An empty status matches every status.
+func (s *Store) List(tenant string, product string, status string) []*Request {
+	return s.requests.List(func(r *Request) bool {
+		return r.Tenant == tenant && r.Product == product && (status == "" || r.Status == status)
+	}, func(a, b *Request) bool {
+		return a.ProposedAt.After(b.ProposedAt)
+	})
+}
+
+// Approve applies a pending request on behalf of approver, who must not be
+// its author, and marks it applied. When apply fails the request stays
+// pending and the error is returned.
+func (s *Store) Approve(id string, approver string, apply func(r *Request) error) (*Request, error) {
+	return s.requests.Update(id, func(r *Request) error {
+		if err := pending(r); err != nil {
+			return err
+		}
+		if approver == "" || approver == r.ProposedBy {
+			return ErrSelfApproval
+		}
+		if err := apply(r); err != nil {
+			return err
+		}
+		review(r, StatusApplied, approver, "")
+		return nil
+	})
+}
+
+// Reject closes a pending request without applying it.
+func (s *Store) Reject(id string, reviewer string, reason string) (*Request, error) {
+	return s.requests.Update(id, func(r *Request) error {
+		if err := pending(r); err != nil {
+			return err
+		}
+		review(r, StatusRejected, reviewer, reason)
+		return nil
+	})
+}
+
+// Expire marks pending requests past their expiry as expired and returns
+// copies of them.
+func (s *Store) Expire() []*Request {
+	now := time.Now().UTC()
+	return s.requests.UpdateAll(proposedBefore, func(r *Request) bool {
+		if r.Status != StatusPending || !now.After(r.ExpiresAt) {
+			return false
+		}
+		review(r, StatusExpired, "", "")
+		return true
+	})
+}
+
+// pending reports why r, which is nil when there is no such request,
+// cannot be reviewed anymore.
+func pending(r *Request) error {
+	if r == nil {
+		return ErrNotFound
+	}
+	// Expired requests are marked as such by Expire
+	if r.Status != StatusPending || time.Now().UTC().After(r.ExpiresAt) {
+		return ErrNotPending
+	}
+	return nil
+}
+
+func review(r *Request, status string, reviewer string, reason string) {
+	now := time.Now().UTC()
+	r.Status = status
+	r.ReviewedBy = reviewer
+	r.ReviewedAt = &now
+	r.Reason = reason
+}
@@ -0,0 +1,204 @@
+package approval
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReview(t *testing.T) {
+	errApply := errors.New("write failed")
+
+	type step struct {
+		action  string // approve, fail, reject, expire or age
+		user    string
+		wantErr error
+	}
+	tests := []struct {
+		name       string
+		steps      []step
+		wantStatus string
+		reviewedBy string
+		applied    int
+	}{
+		{
+			name:       "approved by someone else",
+			steps:      []step{{action: "approve", user: "bob"}},
+			wantStatus: StatusApplied,
+			reviewedBy: "bob",
+			applied:    1,
+		},
+		{
+			name:       "approved by its author",
+			steps:      []step{{action: "approve", user: "alice", wantErr: ErrSelfApproval}},
+			wantStatus: StatusPending,
+		},
+		{
+			name:       "approved anonymously",
+			steps:      []step{{action: "approve", user: "", wantErr: ErrSelfApproval}},
+			wantStatus: StatusPending,
+		},
+		{
+			name:       "author may reject",
+			steps:      []step{{action: "reject", user: "alice"}},
+			wantStatus: StatusRejected,
+			reviewedBy: "alice",
+		},
+		{
+			name: "approved twice",
+			steps: []step{
+				{action: "approve", user: "bob"},
+				{action: "approve", user: "carol", wantErr: ErrNotPending},
+			},
+			wantStatus: StatusApplied,
+			reviewedBy: "bob",
+			applied:    1,
+		},
+		{
+			name: "approved after rejection",
+			steps: []step{
+				{action: "reject", user: "bob"},
+				{action: "approve", user: "carol", wantErr: ErrNotPending},
+			},
+			wantStatus: StatusRejected,
+			reviewedBy: "bob",
+		},
+		{
+			name: "rejected after approval",
+			steps: []step{
+				{action: "approve", user: "bob"},
+				{action: "reject", user: "carol", wantErr: ErrNotPending},
+			},
+			wantStatus: StatusApplied,
+			reviewedBy: "bob",
+			applied:    1,
+		},
+		{
+			name: "failed apply stays pending",
+			steps: []step{
+				{action: "fail", user: "bob", wantErr: errApply},
+				{action: "approve", user: "carol"},
+			},
+			wantStatus: StatusApplied,
+			reviewedBy: "carol",
+			applied:    1,
+		},
+		{
+			name: "past expiry before Expire ran",
+			steps: []step{
+				{action: "age"},
+				{action: "approve", user: "bob", wantErr: ErrNotPending},
+				{action: "reject", user: "bob", wantErr: ErrNotPending},
+			},
+			wantStatus: StatusPending,
+		},
+		{
+			name: "expired",
+			steps: []step{
+				{action: "age"},
+				{action: "expire"},
+				{action: "approve", user: "bob", wantErr: ErrNotPending},
+			},
+			wantStatus: StatusExpired,
+		},
+		{
+			name: "expire leaves reviewed requests alone",
+			steps: []step{
+				{action: "reject", user: "bob"},
+				{action: "age"},
+				{action: "expire"},
+			},
+			wantStatus: StatusRejected,
+			reviewedBy: "bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "change_requests.json")
+			store, err := Open(path, time.Hour)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			req, err := store.Propose(Request{Product: "app", Environment: "production", Set: map[string]string{"a": "1"}, ProposedBy: "alice"})
+			if err != nil {
+				t.Fatalf("Propose() error = %v", err)
+			}
+
+			applied := 0
+			for _, s := range tt.steps {
+				switch s.action {
+				case "approve":
+					_, err = store.Approve(req.ID, s.user, func(*Request) error { applied++; return nil })
+				case "fail":
+					_, err = store.Approve(req.ID, s.user, func(*Request) error { return errApply })
+				case "reject":
+					_, err = store.Reject(req.ID, s.user, "no")
+				case "expire":
+					store.Expire()
+					err = nil
+				case "age":
+					_, err = store.requests.Update(req.ID, func(r *Request) error {
+						r.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+						return nil
+					})
+				}
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("%s by %q: error = %v, want %v", s.action, s.user, err, s.wantErr)
+				}
+			}
+
+			if applied != tt.applied {
+				t.Errorf("applied %d times, want %d", applied, tt.applied)
+			}
+			// The outcome survives a restart
+			reopened, err := Open(path, time.Hour)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			got, ok := reopened.Get(req.ID)
+			if !ok {
+				t.Fatalf("request %s not saved", req.ID)
+			}
+			if got.Status != tt.wantStatus || got.ReviewedBy != tt.reviewedBy {
+				t.Errorf("status %s reviewed by %q, want %s reviewed by %q", got.Status, got.ReviewedBy, tt.wantStatus, tt.reviewedBy)
+			}
+		})
+	}
+}
+
+func TestReviewUnknownRequest(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "change_requests.json"), 0)
+	if _, err := store.Approve("missing", "bob", func(*Request) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Approve() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := store.Reject("missing", "bob", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPropose(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "change_requests.json"), 2*time.Hour)
+	req, err := store.Propose(Request{
+		Product:    "app",
+		Set:        map[string]string{"b": "1", "a": "2"},
+		Remove:     []string{"c"},
+		ProposedBy: "alice",
+		Status:     StatusApplied,
+		ReviewedBy: "alice",
+	})
+	if err != nil {
+		t.Fatalf("Propose() error = %v", err)
+	}
+
+	if req.Status != StatusPending || req.ReviewedBy != "" || req.ReviewedAt != nil {
+		t.Errorf("new request %+v is not pending and unreviewed", req)
+	}
+	if ttl := req.ExpiresAt.Sub(req.ProposedAt); ttl != 2*time.Hour {
+		t.Errorf("expires after %s, want 2h", ttl)
+	}
+	if keys := req.Keys(); len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "c" {
+		t.Errorf("Keys() = %v, want [a b c]", keys)
+	}
+}
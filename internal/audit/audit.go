@@ -1,16 +1,20 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"simpleConfigServer/internal/requestid"
+	"sync"
 	"time"
 )
 
 type AuditEvent struct {
 	Timestamp   string                 `json:"timestamp"`
 	EventType   string                 `json:"event_type"`
+	RequestID   string                 `json:"request_id,omitempty"`
 	ClientIP    string                 `json:"client_ip"`
 	UserID      string                 `json:"user_id,omitempty"`
 	Product     string                 `json:"product,omitempty"`
@@ -20,28 +24,67 @@ type AuditEvent struct {
 	Details     map[string]interface{} `json:"details,omitempty"`
 }
 
-var auditFile *os.File
+// Stream is an audit log written to its own directory, one file per day of
+// startup. The package level functions write to the default stream in
+// audit_logs.
+type Stream struct {
+	log *logFile
+	// requestID is added to every event, see WithContext
+	requestID string
+}
+
+type logFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var defaultStream *Stream
 
 func init() {
+	var err error
+	defaultStream, err = NewStream("audit_logs")
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// NewStream opens an audit log in dir, creating the directory if needed.
+func NewStream(auditDir string) (*Stream, error) {
 	// Create audit directory if it doesn't exist
-	auditDir := "audit_logs"
 	if err := os.MkdirAll(auditDir, 0755); err != nil {
-		panic(fmt.Sprintf("Failed to create audit directory: %v", err))
+		return nil, fmt.Errorf("Failed to create audit directory: %v", err)
 	}
 
 	// Create new audit log file with date
 	filename := filepath.Join(auditDir, fmt.Sprintf("audit_%s.log", time.Now().Format("2006-01-02")))
-	var err error
-	auditFile, err = os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to open audit log file: %v", err))
+		return nil, fmt.Errorf("Failed to open audit log file: %v", err)
 	}
+	return &Stream{log: &logFile{file: file}}, nil
 }
 
-func Log(eventType string, clientIP string, status string, details map[string]interface{}) {
+// WithContext returns a view of the stream that adds the request ID in ctx
+// to every event.
+func (s *Stream) WithContext(ctx context.Context) *Stream {
+	return s.WithRequestID(requestid.FromContext(ctx))
+}
+
+// WithRequestID returns a view of the stream that adds id to every event.
+func (s *Stream) WithRequestID(id string) *Stream {
+	return &Stream{log: s.log, requestID: id}
+}
+
+// Default returns the stream the package level functions write to.
+func Default() *Stream {
+	return defaultStream
+}
+
+func (s *Stream) Log(eventType string, clientIP string, status string, details map[string]interface{}) {
 	event := AuditEvent{
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		EventType: eventType,
+		RequestID: s.requestID,
 		ClientIP:  clientIP,
 		Status:    status,
 		Details:   details,
@@ -53,29 +96,40 @@ func Log(eventType string, clientIP string, status string, details map[string]in
 		return
 	}
 
-	if _, err := auditFile.Write(append(jsonData, '\n')); err != nil {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+	if _, err := s.log.file.Write(append(jsonData, '\n')); err != nil {
 		fmt.Printf("Failed to write audit log: %v\n", err)
 	}
 }
 
-func LogAuth(clientIP string, status string, userID string) {
+func (s *Stream) LogAuth(clientIP string, status string, userID string) {
 	details := map[string]interface{}{
 		"user_id": userID,
 	}
-	Log("AUTH", clientIP, status, details)
+	s.Log("AUTH", clientIP, status, details)
 }
 
-func LogConfigAccess(clientIP string, status string, product string, env string, configKey string, userID string) {
+func (s *Stream) LogConfigAccess(clientIP string, status string, product string, env string, configKey string, userID string) {
 	details := map[string]interface{}{
 		"product":     product,
 		"environment": env,
 		"config_key":  configKey,
 		"user_id":     userID,
 	}
-	Log("CONFIG_ACCESS", clientIP, status, details)
+	s.Log("CONFIG_ACCESS", clientIP, status, details)
 }
 
-func LogConfigChange(clientIP string, status string, product string, env string, configKey string, oldValue string, newValue string, userID string) {
+func (s *Stream) LogConfigList(clientIP string, status string, product string, env string, userID string) {
+	details := map[string]interface{}{
+		"product":     product,
+		"environment": env,
+		"user_id":     userID,
+	}
+	s.Log("CONFIG_LIST", clientIP, status, details)
+}
+
+func (s *Stream) LogConfigChange(clientIP string, status string, product string, env string, configKey string, oldValue string, newValue string, userID string) {
 	details := map[string]interface{}{
 		"product":     product,
 		"environment": env,
@@ -84,13 +138,101 @@ func LogConfigChange(clientIP string, status string, product string, env string,
 		"new_value":   newValue,
 		"user_id":     userID,
 	}
-	Log("CONFIG_CHANGE", clientIP, status, details)
+	s.Log("CONFIG_CHANGE", clientIP, status, details)
+}
+
+func (s *Stream) LogFlagEvaluation(clientIP string, status string, product string, env string, flag string, rule string, userID string) {
+	details := map[string]interface{}{
+		"product":     product,
+		"environment": env,
+		"flag":        flag,
+		"rule":        rule,
+		"user_id":     userID,
+	}
+	s.Log("FLAG_EVALUATION", clientIP, status, details)
+}
+
+func (s *Stream) LogConfigDiff(clientIP string, status string, product string, fromEnv string, toEnv string, userID string) {
+	details := map[string]interface{}{
+		"product": product,
+		"from":    fromEnv,
+		"to":      toEnv,
+		"user_id": userID,
+	}
+	s.Log("CONFIG_DIFF", clientIP, status, details)
+}
+
+func (s *Stream) LogChangeRequest(clientIP string, status string, requestID string, product string, env string, keys []string, userID string) {
+	details := map[string]interface{}{
+		"change_request_id": requestID,
+		"product":           product,
+		"environment":       env,
+		"keys":              keys,
+		"user_id":           userID,
+	}
+	s.Log("CHANGE_REQUEST", clientIP, status, details)
+}
+
+func (s *Stream) LogScheduledChange(clientIP string, status string, changeID string, product string, env string, keys []string, effectiveAt string, userID string) {
+	details := map[string]interface{}{
+		"change_id":    changeID,
+		"product":      product,
+		"environment":  env,
+		"keys":         keys,
+		"effective_at": effectiveAt,
+		"user_id":      userID,
+	}
+	s.Log("SCHEDULED_CHANGE", clientIP, status, details)
+}
+
+func (s *Stream) LogSecurity(clientIP string, status string, eventType string, details map[string]interface{}) {
+	s.Log(eventType, clientIP, status, details)
+}
+
+func (s *Stream) LogSystem(eventType string, status string, details map[string]interface{}) {
+	s.Log(eventType, "SYSTEM", status, details)
+}
+
+func Log(eventType string, clientIP string, status string, details map[string]interface{}) {
+	defaultStream.Log(eventType, clientIP, status, details)
+}
+
+func LogAuth(clientIP string, status string, userID string) {
+	defaultStream.LogAuth(clientIP, status, userID)
+}
+
+func LogConfigAccess(clientIP string, status string, product string, env string, configKey string, userID string) {
+	defaultStream.LogConfigAccess(clientIP, status, product, env, configKey, userID)
+}
+
+func LogConfigList(clientIP string, status string, product string, env string, userID string) {
+	defaultStream.LogConfigList(clientIP, status, product, env, userID)
+}
+
+func LogConfigChange(clientIP string, status string, product string, env string, configKey string, oldValue string, newValue string, userID string) {
+	defaultStream.LogConfigChange(clientIP, status, product, env, configKey, oldValue, newValue, userID)
+}
+
+func LogFlagEvaluation(clientIP string, status string, product string, env string, flag string, rule string, userID string) {
+	defaultStream.LogFlagEvaluation(clientIP, status, product, env, flag, rule, userID)
+}
+
+func LogConfigDiff(clientIP string, status string, product string, fromEnv string, toEnv string, userID string) {
+	defaultStream.LogConfigDiff(clientIP, status, product, fromEnv, toEnv, userID)
+}
+
+func LogChangeRequest(clientIP string, status string, requestID string, product string, env string, keys []string, userID string) {
+	defaultStream.LogChangeRequest(clientIP, status, requestID, product, env, keys, userID)
+}
+
+func LogScheduledChange(clientIP string, status string, changeID string, product string, env string, keys []string, effectiveAt string, userID string) {
+	defaultStream.LogScheduledChange(clientIP, status, changeID, product, env, keys, effectiveAt, userID)
 }
 
 func LogSecurity(clientIP string, status string, eventType string, details map[string]interface{}) {
-	Log(eventType, clientIP, status, details)
+	defaultStream.LogSecurity(clientIP, status, eventType, details)
 }
 
 func LogSystem(eventType string, status string, details map[string]interface{}) {
-	Log(eventType, "SYSTEM", status, details)
+	defaultStream.LogSystem(eventType, status, details)
 }
@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,26 +14,139 @@ import (
 type Claims struct {
 	UserID string `json:"user_id"`
 	jwt.StandardClaims
+
+	// Attributes holds every claim of the token, including custom ones
+	Attributes map[string]interface{} `json:"-"`
 }
 
 var jwtSecret = os.Getenv("JWT_SECRET")
 
+// Validator checks tokens signed with one HMAC secret and, when Issuer is
+// set, issued by one issuer.
+type Validator struct {
+	Secret []byte
+	Issuer string
+}
+
+var defaultValidator = &Validator{Secret: []byte(jwtSecret)}
+
+// Default returns the validator used by ValidateJWT, configured from the
+// JWT_SECRET environment variable.
+func Default() *Validator {
+	return defaultValidator
+}
+
 func ValidateJWT(tokenString string) (*Claims, bool) {
+	return defaultValidator.Validate(tokenString)
+}
+
+// Errors returned by Check
+var (
+	ErrTokenMissing = errors.New("no token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenInvalid = errors.New("invalid token")
+)
+
+func (v *Validator) Validate(tokenString string) (*Claims, bool) {
+	claims, err := v.Check(tokenString)
+	return claims, err == nil
+}
+
+// Check validates a token like Validate and reports why a token was
+// rejected.
+func (v *Validator) Check(tokenString string) (*Claims, error) {
+	if tokenString == "" {
+		return nil, ErrTokenMissing
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(jwtSecret), nil
+		return v.Secret, nil
 	})
 
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+		return nil, ErrTokenExpired
+	}
 	if err != nil || !token.Valid {
-		return nil, false
+		return nil, ErrTokenInvalid
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
-		return nil, false
+		return nil, ErrTokenInvalid
+	}
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return nil, ErrTokenInvalid
+	}
+
+	// The signature was verified above, so the payload can be decoded again
+	// to expose custom claims.
+	attributes := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, attributes); err == nil {
+		claims.Attributes = attributes
+	}
+
+	return claims, nil
+}
+
+// Claim returns a claim of the token rendered as a string.
+func (c *Claims) Claim(name string) (string, bool) {
+	value, ok := c.Attributes[name]
+	if !ok || value == nil {
+		return "", false
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(value), true
+}
+
+// HasRole reports whether the token grants role, either in a "roles" claim
+// holding a list or comma separated string, or in a "role" claim.
+func (c *Claims) HasRole(role string) bool {
+	granted := c.list("roles")
+	if r, ok := c.Claim("role"); ok {
+		granted = append(granted, r)
 	}
 
-	return claims, true
+	for _, r := range granted {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsProduct reports whether the token may access product. Tokens
+// without a "products" claim may access every product; otherwise the claim
+// lists the allowed products as names or glob patterns such as "billing-*".
+func (c *Claims) AllowsProduct(product string) bool {
+	if _, ok := c.Attributes["products"]; !ok {
+		return true
+	}
+	for _, pattern := range c.list("products") {
+		if matched, _ := path.Match(pattern, product); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns a claim holding a list or a comma separated string.
+func (c *Claims) list(name string) []string {
+	var values []string
+	switch claim := c.Attributes[name].(type) {
+	case []interface{}:
+		for _, v := range claim {
+			values = append(values, strings.TrimSpace(fmt.Sprint(v)))
+		}
+	case string:
+		for _, v := range strings.Split(claim, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+	return values
 }
@@ -0,0 +1,189 @@
+// Package changestore keeps records of pending config changes, such as
+// change requests and scheduled changes, in a JSON file that is rewritten
+// on every state transition so that they survive restarts.
+package changestore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store holds records of type T by ID. Records are handed out as copies;
+// they are only changed through Update and UpdateAll, under the store's
+// lock.
+type Store[T any] struct {
+	path    string
+	id      func(r *T) string
+	order   func(a, b *T) bool
+	mu      sync.Mutex
+	records map[string]*T
+}
+
+// New returns a store that keeps records in memory only. id returns the ID
+// of a record and order sorts records in the file.
+func New[T any](id func(r *T) string, order func(a, b *T) bool) *Store[T] {
+	return &Store[T]{id: id, order: order, records: make(map[string]*T)}
+}
+
+// Open returns a store persisting to path, reading the records saved there.
+// A missing file is an empty store.
+func Open[T any](path string, id func(r *T) string, order func(a, b *T) bool) (*Store[T], error) {
+	s := New(id, order)
+	s.path = path
+
+	bytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*T
+	if err := json.Unmarshal(bytes, &records); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, r := range records {
+		s.records[id(r)] = r
+	}
+	return s, nil
+}
+
+// NewID returns a random ID for a new record.
+func NewID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Add stores a new record, whose ID is already set, and returns a copy of
+// it. Nothing is stored when the file cannot be written.
+func (s *Store[T]) Add(r T) (*T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.id(&r)
+	s.records[id] = &r
+	if err := s.save(); err != nil {
+		delete(s.records, id)
+		return nil, err
+	}
+	return clone(&r), nil
+}
+
+// Get returns a copy of the record with the given ID.
+func (s *Store[T]) Get(id string) (*T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return nil, false
+	}
+	return clone(r), true
+}
+
+// List returns copies of the records match accepts, sorted by less.
+func (s *Store[T]) List(match func(r *T) bool, less func(a, b *T) bool) []*T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*T
+	for _, r := range s.records {
+		if match(r) {
+			list = append(list, clone(r))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return less(list[i], list[j]) })
+	return list
+}
+
+// Update calls change with the record with the given ID, or with nil when
+// there is none, and saves the store unless change fails. It returns a copy
+// of the changed record.
+func (s *Store[T]) Update(id string, change func(r *T) error) (*T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.records[id]
+	if err := change(r); err != nil {
+		return nil, err
+	}
+	return clone(r), s.save()
+}
+
+// UpdateAll calls change for every record, in the order of less, and saves
+// the store when change reported a change for any of them. It returns
+// copies of the changed records.
+func (s *Store[T]) UpdateAll(less func(a, b *T) bool, change func(r *T) bool) []*T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*T, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return less(records[i], records[j]) })
+
+	var changed []*T
+	for _, r := range records {
+		if change(r) {
+			changed = append(changed, clone(r))
+		}
+	}
+	if len(changed) > 0 {
+		s.save()
+	}
+	return changed
+}
+
+// save writes every record to the store's file atomically. It is a no-op
+// for stores that are not backed by a file.
+func (s *Store[T]) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	records := make([]*T, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return s.order(records[i], records[j]) })
+
+	bytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, bytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func clone[T any](r *T) *T {
+	out := *r
+	return &out
+}
+
+// Keys lists the keys a change sets or removes, in name order.
+func Keys(set map[string]string, remove []string) []string {
+	keys := make([]string, 0, len(set)+len(remove))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	keys = append(keys, remove...)
+	sort.Strings(keys)
+	return keys
+}
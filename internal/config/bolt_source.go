@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var documentsBucket = []byte("documents")
+
+// BoltSource keeps documents in an embedded bbolt database, one key per
+// product environment. Document IDs are "{product}/{environment}" and the
+// values use the same YAML layout as config files.
+type BoltSource struct {
+	path string
+	db   *bolt.DB
+}
+
+func NewBoltSource(path string) (*BoltSource, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(documentsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltSource{path: path, db: db}, nil
+}
+
+func (s *BoltSource) Name() string {
+	return "bolt:" + s.path
+}
+
+func (s *BoltSource) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltSource) List() ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(documentsBucket).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+func (s *BoltSource) Read(id string) (*Document, error) {
+	i := strings.LastIndex(id, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid document id %q", id)
+	}
+	product, env := id[:i], id[i+1:]
+
+	var bytes []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(documentsBucket).Get([]byte(id))
+		if value == nil {
+			return fmt.Errorf("document %s: %w", id, fs.ErrNotExist)
+		}
+		bytes = append([]byte(nil), value...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDocument(product, env, bytes)
+}
+
+func (s *BoltSource) Write(doc *Document, change Change) (string, error) {
+	id := doc.Product + "/" + doc.Environment
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(documentsBucket)
+		bytes, err := marshalDocument(doc, bucket.Get([]byte(id)))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), bytes)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Watch never reports a change and never returns. The database file is
+// locked by the server, so the only changes are writes made through
+// WriteDocument, which reloads the written document itself.
+func (s *BoltSource) Watch(onChange func(id string)) {
+	select {}
+}
+
+// Import copies every document of another source into the database.
+func (s *BoltSource) Import(from Source) (int, error) {
+	ids, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, id := range ids {
+		doc, err := from.Read(id)
+		if err != nil {
+			return imported, fmt.Errorf("%s: %w", id, err)
+		}
+		change := Change{UserID: "SYSTEM", Message: "Import " + id + " from " + from.Name()}
+		if _, err := s.Write(doc, change); err != nil {
+			return imported, fmt.Errorf("%s: %w", id, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
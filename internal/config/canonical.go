@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// typedConfig decodes the same document as Config but keeps the YAML type
+// of every scalar.
+type typedConfig struct {
+	Configs map[string]interface{} `yaml:"configs"`
+}
+
+// canonicalConfigs returns the single spelling of the values in configs that
+// are typed scalars, so that "yes", "True" and "on" can be served as "true",
+// 0x1F as "31" and ~ as an empty value. Values are only listed when the
+// spelling means exactly the same: numbers other than hexadecimal integers
+// are left out, since 1.10, 0123 or integers too large for int64 would not
+// survive the round trip. Strings, including quoted ones, are left out.
+func canonicalConfigs(configs map[string]string, typed map[string]interface{}) map[string]string {
+	canonical := make(map[string]string)
+	for key, value := range typed {
+		if spelling, ok := canonicalValue(configs[key], value); ok && spelling != configs[key] {
+			canonical[key] = spelling
+		}
+	}
+	return canonical
+}
+
+func canonicalValue(text string, value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int64, uint64:
+		return canonicalHex(text)
+	default:
+		return "", false
+	}
+}
+
+// canonicalHex renders a hexadecimal integer in decimal.
+func canonicalHex(text string) (string, bool) {
+	digits := strings.TrimLeft(text, "+-")
+	if !strings.HasPrefix(digits, "0x") {
+		return "", false
+	}
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return strconv.FormatInt(n, 10), true
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(text, "+"), 0, 64); err == nil {
+		return strconv.FormatUint(n, 10), true
+	}
+	return "", false
+}
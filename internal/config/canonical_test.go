@@ -0,0 +1,45 @@
+package config
+
+import "testing"
+
+func TestParseDocumentKeepsScalarText(t *testing.T) {
+	tests := []struct {
+		yaml      string
+		value     string
+		canonical string
+		typed     bool
+	}{
+		{yaml: `yes`, value: "yes", canonical: "true", typed: true},
+		{yaml: `On`, value: "On", canonical: "true", typed: true},
+		{yaml: `False`, value: "False", canonical: "false", typed: true},
+		{yaml: `true`, value: "true"},
+		{yaml: `~`, value: ""},
+		{yaml: `0x1F`, value: "0x1F", canonical: "31", typed: true},
+		{yaml: `0xFFFFFFFFFFFFFFFF`, value: "0xFFFFFFFFFFFFFFFF", canonical: "18446744073709551615", typed: true},
+		{yaml: `1.10`, value: "1.10"},
+		{yaml: `1.0`, value: "1.0"},
+		{yaml: `01234`, value: "01234"},
+		{yaml: `+5`, value: "+5"},
+		{yaml: `123456789012345678901234`, value: "123456789012345678901234"},
+		{yaml: `1e3`, value: "1e3"},
+		{yaml: `.inf`, value: ".inf"},
+		{yaml: `"yes"`, value: "yes"},
+		{yaml: `'0x1F'`, value: "0x1F"},
+		{yaml: `plain`, value: "plain"},
+	}
+
+	for _, tt := range tests {
+		doc, err := parseDocument("app", "dev", []byte("configs:\n  key: "+tt.yaml+"\n"))
+		if err != nil {
+			t.Errorf("%s: parseDocument() error = %v", tt.yaml, err)
+			continue
+		}
+		if got := doc.Configs["key"]; got != tt.value {
+			t.Errorf("%s: value %q, want %q", tt.yaml, got, tt.value)
+		}
+		canonical, typed := doc.Canonical["key"]
+		if typed != tt.typed || canonical != tt.canonical {
+			t.Errorf("%s: canonical %q (%v), want %q (%v)", tt.yaml, canonical, typed, tt.canonical, tt.typed)
+		}
+	}
+}
@@ -1,108 +1,619 @@
 package config
 
 import (
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
 	"path/filepath"
 	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/flags"
 	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/metrics"
+	"simpleConfigServer/internal/requestid"
+	"simpleConfigServer/internal/tracing"
+	"sort"
 	"strings"
 	"sync"
 
-	"gopkg.in/yaml.v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var configStore = make(map[string]map[string]string)
+// current is the Store served to handlers. It is replaced as a whole on
+// every load, see snapshot.
+var current = newSnapshot()
+var activeSource Source
 var configLoadMux sync.Mutex
 var mu sync.RWMutex
 
-type Config struct {
-	Configs map[string]string `yaml:"configs"`
+// changed is closed, and replaced, whenever a new snapshot is published.
+var changed = make(chan struct{})
+
+// LoadConfigs reads every document of source and makes it the source that
+// later reloads and writes go to. Placeholders are resolved once all
+// documents have been read.
+func LoadConfigs(source Source) {
+	configLoadMux.Lock()
+	defer configLoadMux.Unlock()
+
+	mu.Lock()
+	activeSource = source
+	mu.Unlock()
+
+	if err := loadAll(context.Background(), source); err != nil {
+		logger.Log.Fatalf("Error listing configs in %s: %v", source.Name(), err)
+	}
+	setStatus(func(s *Status) { s.Loaded = true })
 }
 
-func LoadConfigs(configPath string) {
+// Reload reads every document of the active source again, in a span below
+// ctx. Unlike LoadConfigs it reports a source that cannot be listed rather
+// than exiting, and keeps serving the configs loaded before.
+func Reload(ctx context.Context) error {
 	configLoadMux.Lock()
 	defer configLoadMux.Unlock()
 
-	err := filepath.Walk(configPath, func(path string, info os.FileInfo, err error) error {
+	mu.RLock()
+	source := activeSource
+	mu.RUnlock()
+
+	if err := loadAll(ctx, source); err != nil {
+		logger.For(ctx).Printf("Error listing configs in %s: %v", source.Name(), err)
+		sourceFailed(err)
+		return err
+	}
+	sourceRead()
+	return nil
+}
+
+// loadAll reads every document of source and resolves placeholders once
+// all of them have been read. Documents no longer listed are dropped, while
+// those that cannot be read keep their previous values. Callers hold
+// configLoadMux.
+func loadAll(ctx context.Context, source Source) error {
+	mu.RLock()
+	previous := current
+	mu.RUnlock()
+
+	ctx, span := tracing.Start(ctx, "config.load_all", attribute.String("config.source", source.Name()))
+	defer span.End()
+
+	ids, err := source.List()
+	if err != nil {
+		tracing.Fail(span, err)
+		return err
+	}
+	forgetDocuments(ids)
+
+	raw := make(map[string]map[string]map[string]string)
+	canonical := make(map[string]map[string]map[string]string)
+	envFlags := make(map[string]map[string]map[string]flags.Flag)
+	for _, id := range ids {
+		doc, err := readDocument(ctx, source, id)
+		if errors.Is(err, fs.ErrNotExist) {
+			forgetDocument(id)
+			continue
+		}
 		if err != nil {
-			return err
+			product, env, loaded := loadedEnvironment(id)
+			if _, found := previous.raw[product][env]; !loaded || !found {
+				continue
+			}
+			doc = &Document{
+				Product:     product,
+				Environment: env,
+				Configs:     previous.raw[product][env],
+				Flags:       previous.flags[product][env],
+				Canonical:   previous.canonical[product][env],
+			}
+		} else {
+			logger.For(ctx).Printf("Loaded config file: %s", id)
 		}
-		if info.IsDir() {
-			return nil
+		raw = withEnvironment(raw, doc.Product, doc.Environment, doc.Configs)
+		canonical = withEnvironment(canonical, doc.Product, doc.Environment, doc.Canonical)
+		envFlags = withEnvironment(envFlags, doc.Product, doc.Environment, doc.Flags)
+	}
+
+	resolveConfigs(ctx, raw, canonical, envFlags)
+	return nil
+}
+
+// LoadDocument reloads a single document of the active source and
+// re-resolves all values, since other products may reference keys defined
+// in it. A document the source no longer has is dropped.
+func LoadDocument(id string) {
+	configLoadMux.Lock()
+	defer configLoadMux.Unlock()
+
+	loadDocument(context.Background(), id)
+}
+
+// loadDocument is LoadDocument for the request in ctx, whose ID is added
+// to the log lines and audit events of the reload. Callers hold
+// configLoadMux.
+func loadDocument(ctx context.Context, id string) {
+	mu.RLock()
+	source := activeSource
+	raw, canonical, envFlags := current.raw, current.canonical, current.flags
+	mu.RUnlock()
+
+	doc, err := readDocument(ctx, source, id)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		product, env, loaded := loadedEnvironment(id)
+		forgetDocument(id)
+		if !loaded {
+			return
 		}
-		if filepath.Ext(path) == ".yml" {
-			LoadConfigFile(path)
-			logger.Log.Printf("Loaded config file: %s", path)
+		logger.For(ctx).Printf("Removed configs for %s/%s", product, env)
+		audit.Default().WithContext(ctx).LogSystem("CONFIG_LOAD", "REMOVED", map[string]interface{}{
+			"document":    id,
+			"product":     product,
+			"environment": env,
+		})
+		raw = withoutEnvironment(raw, product, env)
+		canonical = withoutEnvironment(canonical, product, env)
+		envFlags = withoutEnvironment(envFlags, product, env)
+		resolveConfigs(ctx, raw, canonical, envFlags)
+	case err == nil:
+		raw = withEnvironment(raw, doc.Product, doc.Environment, doc.Configs)
+		canonical = withEnvironment(canonical, doc.Product, doc.Environment, doc.Canonical)
+		envFlags = withEnvironment(envFlags, doc.Product, doc.Environment, doc.Flags)
+		resolveConfigs(ctx, raw, canonical, envFlags)
+	}
+}
+
+// WatchConfigs reloads documents of the active source as they change. It
+// blocks for as long as the source is watched.
+func WatchConfigs() {
+	mu.RLock()
+	source := activeSource
+	mu.RUnlock()
+
+	setStatus(func(s *Status) { s.Watching = true })
+	source.Watch(LoadDocument)
+	setStatus(func(s *Status) { s.Watching = false })
+}
+
+// WriteDocument stores doc in the active source on behalf of change.UserID
+// and loads it right away. When change.Message is empty a message listing
+// the changed keys is generated.
+func WriteDocument(doc *Document, change Change) error {
+	return writeDocument(GetSource(), doc, change)
+}
+
+// writeDocument stores doc in source and, if source is the active source,
+// loads it. Other sources are only written to, e.g. by the promote command,
+// which does not load any configs. Reloads by the watcher wait until the
+// written document is loaded, so that the changes are audited with the
+// ID of the request that wrote them.
+func writeDocument(source Source, doc *Document, change Change) error {
+	configLoadMux.Lock()
+	defer configLoadMux.Unlock()
+
+	mu.RLock()
+	active := source == activeSource
+	oldConfigs := current.raw[doc.Product][doc.Environment]
+	mu.RUnlock()
+
+	if change.Message == "" {
+		change.Message = describeChange(doc, oldConfigs)
+	}
+
+	ctx := requestid.NewContext(context.Background(), change.RequestID)
+	stream := audit.Default().WithContext(ctx)
+	id, err := source.Write(doc, change)
+	if err != nil {
+		logger.For(ctx).Printf("Failed to write %s/%s to %s: %v", doc.Product, doc.Environment, source.Name(), err)
+		stream.LogSystem("CONFIG_WRITE", "FAILED", map[string]interface{}{
+			"source":      source.Name(),
+			"product":     doc.Product,
+			"environment": doc.Environment,
+			"user_id":     change.UserID,
+			"error":       err.Error(),
+		})
+		// The document may have been stored even though recording the
+		// change failed; serve what is stored.
+		if id != "" && active {
+			loadDocument(ctx, id)
 		}
-		return nil
+		return err
+	}
+	stream.LogSystem("CONFIG_WRITE", "SUCCESS", map[string]interface{}{
+		"source":      source.Name(),
+		"document":    id,
+		"product":     doc.Product,
+		"environment": doc.Environment,
+		"user_id":     change.UserID,
+		"message":     change.Message,
 	})
 
+	if active {
+		loadDocument(ctx, id)
+	}
+	return nil
+}
+
+// editMux serialises edits that read the loaded configs and write them back,
+// e.g. promotions, so that concurrent edits of one environment cannot
+// overwrite each other.
+var editMux sync.Mutex
+
+// ErrEnvPlaceholder is returned by UpdateConfigs and PlanPromotion for
+// values with an ${env:NAME} placeholder, which only config files may use.
+var ErrEnvPlaceholder = errors.New("${env:...} placeholders are not allowed in values written through the API")
+
+// UpdateConfigs sets and removes keys of a product environment, keeping its
+// other keys and flags, and writes it through WriteDocument. The document is
+// edited as stored in the active source, rather than as last loaded, so
+// that edits not picked up by the watcher yet and flags that failed
+// validation are not lost. The environment is created when it does not
+// exist yet.
+func UpdateConfigs(product string, env string, set map[string]string, remove []string, change Change) error {
+	for key, value := range set {
+		if HasEnvPlaceholder(value) {
+			return fmt.Errorf("key %s: %w", key, ErrEnvPlaceholder)
+		}
+	}
+
+	editMux.Lock()
+	defer editMux.Unlock()
+
+	source := GetSource()
+	stored, err := readStoredDocument(source, product, env)
 	if err != nil {
-		logger.Log.Fatalf("Error walking config directory: %v", err)
+		return err
+	}
+
+	configs := make(map[string]string)
+	for key, value := range stored.Configs {
+		configs[key] = value
+	}
+	for key, value := range set {
+		configs[key] = value
+	}
+	for _, key := range remove {
+		delete(configs, key)
 	}
+
+	doc := &Document{Product: product, Environment: env, Configs: configs, Flags: stored.Flags, Canonical: stored.Canonical}
+	return writeDocument(source, doc, change)
 }
 
-func LoadConfigFile(path string) {
-	bytes, err := os.ReadFile(path)
+// readStoredDocument reads the document of a product environment as stored
+// in source, with its flags as written. An empty document is returned when
+// source has none.
+func readStoredDocument(source Source, product string, env string) (*Document, error) {
+	ids, err := source.List()
 	if err != nil {
-		logger.Log.Printf("Failed to read %s: %v", path)
-		audit.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
-			"file":  path,
-			"error": err.Error(),
-		})
-		return
+		return nil, err
+	}
+	for _, id := range ids {
+		// Every source names documents .../{product}/{env}
+		name := filepath.ToSlash(id)
+		dir, base := path.Dir(name), path.Base(name)
+		if strings.TrimSuffix(base, path.Ext(base)) != env || dir != product && !strings.HasSuffix(dir, "/"+product) {
+			continue
+		}
+		doc, err := source.Read(id)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc.Product == product && doc.Environment == env {
+			return doc, nil
+		}
 	}
+	return &Document{Product: product, Environment: env}, nil
+}
 
-	var config Config
-	if err := yaml.Unmarshal(bytes, &config); err != nil {
-		logger.Log.Printf("Failed to parse YAML %s: %v", path)
-		audit.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
-			"file":  path,
-			"error": err.Error(),
-		})
-		return
+// describeChange summarises the keys doc adds, updates and removes, e.g.
+// "Update sample/production: set api_url, timeout; remove legacy_url".
+func describeChange(doc *Document, oldConfigs map[string]string) string {
+	var set, removed []string
+	for key, value := range doc.Configs {
+		if oldValue, exists := oldConfigs[key]; !exists || oldValue != value {
+			set = append(set, key)
+		}
 	}
+	for key := range oldConfigs {
+		if _, exists := doc.Configs[key]; !exists {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(set)
+	sort.Strings(removed)
 
-	parts := strings.Split(path, "/")
-	if len(parts) < 2 {
-		logger.Log.Printf("Invalid path structure: %s", path)
-		audit.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
-			"file":  path,
-			"error": "Invalid path structure",
+	var parts []string
+	if len(set) > 0 {
+		parts = append(parts, "set "+strings.Join(set, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "remove "+strings.Join(removed, ", "))
+	}
+	message := "Update " + doc.Product + "/" + doc.Environment
+	if len(parts) > 0 {
+		message += ": " + strings.Join(parts, "; ")
+	}
+	return message
+}
+
+// readDocument reads a document of source in a span below ctx, dropping
+// invalid flags. Failures are logged and audited, except for documents that
+// do not exist, which are left to the caller.
+func readDocument(ctx context.Context, source Source, id string) (*Document, error) {
+	_, span := tracing.Start(ctx, "config.load", attribute.String("config.document", id))
+	defer span.End()
+	log, stream := logger.For(ctx), audit.Default().WithContext(ctx)
+
+	doc, err := source.Read(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Printf("Failed to read %s: %v", id, err)
+		stream.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
+			"document": id,
+			"error":    err.Error(),
 		})
-		return
+		// Every source names documents .../{product}/{env}, so the product
+		// can be told even when the document cannot be parsed
+		metrics.ConfigLoadFailed(path.Base(path.Dir(filepath.ToSlash(id))))
+		documentRead(source, id, nil, err)
+		return nil, err
+	}
+
+	var flagErr error
+	validFlags := make(map[string]flags.Flag, len(doc.Flags))
+	for name, flag := range doc.Flags {
+		if err := flag.Validate(); err != nil {
+			flagErr = fmt.Errorf("flag %s: %w", name, err)
+			log.Printf("Invalid flag %s in %s: %v", name, id, err)
+			stream.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
+				"document": id,
+				"flag":     name,
+				"error":    err.Error(),
+			})
+			metrics.ConfigLoadFailed(doc.Product)
+			continue
+		}
+		validFlags[name] = flag
+	}
+	doc.Flags = validFlags
+	documentRead(source, id, doc, flagErr)
+
+	log.Printf("Loaded configs for %s/%s", doc.Product, doc.Environment)
+	stream.LogSystem("CONFIG_LOAD", "SUCCESS", map[string]interface{}{
+		"document":    id,
+		"product":     doc.Product,
+		"environment": doc.Environment,
+	})
+	metrics.ConfigLoaded(doc.Product)
+	span.SetAttributes(
+		attribute.String("config.product", doc.Product),
+		attribute.String("config.env", doc.Environment),
+		attribute.Int("config.keys", len(doc.Configs)))
+	return doc, nil
+}
+
+// withEnvironment returns a copy of store with the values of one product
+// environment replaced.
+func withEnvironment[V any](store map[string]map[string]map[string]V, product string, env string, values map[string]V) map[string]map[string]map[string]V {
+	envs := make(map[string]map[string]V, len(store[product])+1)
+	for name, existing := range store[product] {
+		envs[name] = existing
 	}
+	envs[env] = values
+
+	updated := make(map[string]map[string]map[string]V, len(store)+1)
+	for name, products := range store {
+		updated[name] = products
+	}
+	updated[product] = envs
+	return updated
+}
+
+// withoutEnvironment returns a copy of store without one product
+// environment, and without the product once it has no environment left.
+func withoutEnvironment[V any](store map[string]map[string]map[string]V, product string, env string) map[string]map[string]map[string]V {
+	updated := make(map[string]map[string]map[string]V, len(store))
+	for name, products := range store {
+		updated[name] = products
+	}
+
+	envs := make(map[string]map[string]V, len(store[product]))
+	for name, existing := range store[product] {
+		if name != env {
+			envs[name] = existing
+		}
+	}
+	if len(envs) == 0 {
+		delete(updated, product)
+	} else {
+		updated[product] = envs
+	}
+	return updated
+}
+
+// resolveConfigs publishes a new snapshot built from raw and audits every
+// resolved value that changed as a result, with the request ID in ctx.
+func resolveConfigs(ctx context.Context, raw map[string]map[string]map[string]string, canonical map[string]map[string]map[string]string, envFlags map[string]map[string]map[string]flags.Flag) {
+	resolved, errs := resolveStore(raw)
+	log, stream := logger.For(ctx), audit.Default().WithContext(ctx)
 
-	product := filepath.Base(filepath.Dir(path))
 	mu.Lock()
-	oldConfigs := configStore[product]
-	configStore[product] = config.Configs
+	oldStore := current.resolved
+	current = &snapshot{raw: raw, resolved: resolved, canonical: canonical, flags: envFlags}
+	close(changed)
+	changed = make(chan struct{})
 	mu.Unlock()
 
-	// Log configuration changes
-	for key, newValue := range config.Configs {
-		oldValue, exists := oldConfigs[key]
-		if !exists {
-			audit.LogConfigChange("SYSTEM", "ADDED", product, "", key, "", newValue, "SYSTEM")
-		} else if oldValue != newValue {
-			audit.LogConfigChange("SYSTEM", "UPDATED", product, "", key, oldValue, newValue, "SYSTEM")
+	keysByProduct := make(map[string]int, len(raw))
+	for product, envs := range raw {
+		for _, configs := range envs {
+			keysByProduct[product] += len(configs)
+		}
+	}
+	metrics.SetLoaded(keysByProduct)
+
+	for _, err := range errs {
+		log.Printf("Failed to resolve config value: %v", err)
+		stream.LogSystem("CONFIG_LOAD", "FAILED", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	for product, envs := range resolved {
+		for env, configs := range envs {
+			oldConfigs := oldStore[product][env]
+
+			// Log configuration changes
+			for key, newValue := range configs {
+				oldValue, exists := oldConfigs[key]
+				if !exists {
+					stream.LogConfigChange("SYSTEM", "ADDED", product, env, key, "", newValue, "SYSTEM")
+				} else if oldValue != newValue {
+					stream.LogConfigChange("SYSTEM", "UPDATED", product, env, key, oldValue, newValue, "SYSTEM")
+				}
+			}
+
+			// Log removed configurations
+			for key, oldValue := range oldConfigs {
+				if _, exists := configs[key]; !exists {
+					stream.LogConfigChange("SYSTEM", "REMOVED", product, env, key, oldValue, "", "SYSTEM")
+				}
+			}
 		}
 	}
 
-	// Log removed configurations
-	for key, oldValue := range oldConfigs {
-		if _, exists := config.Configs[key]; !exists {
-			audit.LogConfigChange("SYSTEM", "REMOVED", product, "", key, oldValue, "", "SYSTEM")
+	// Log configurations of removed environments
+	for product, envs := range oldStore {
+		for env, oldConfigs := range envs {
+			if _, exists := resolved[product][env]; exists {
+				continue
+			}
+			for key, oldValue := range oldConfigs {
+				stream.LogConfigChange("SYSTEM", "REMOVED", product, env, key, oldValue, "", "SYSTEM")
+			}
 		}
 	}
+}
+
+// GetStore returns the currently loaded configs.
+func GetStore() Store {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Changed returns a channel that is closed the next time the loaded configs
+// are replaced. Read the store after calling Changed so no change is missed.
+func Changed() <-chan struct{} {
+	mu.RLock()
+	defer mu.RUnlock()
+	return changed
+}
+
+// maxRevisionStores bounds the number of historical revisions kept in memory.
+const maxRevisionStores = 16
+
+var revisionStores = make(map[string]Store)
+var revisionMux sync.Mutex
+
+// ErrNotVersioned is returned by GetStoreAt when the active source does not
+// keep history.
+var ErrNotVersioned = errors.New("config source does not keep revisions")
+
+// GetStoreAt returns the configs as of ref, e.g. a commit SHA or tag, along
+// with the revision ref resolved to.
+func GetStoreAt(ref string) (Store, string, error) {
+	source, ok := GetSource().(VersionedSource)
+	if !ok {
+		return nil, "", ErrNotVersioned
+	}
 
-	logger.Log.Printf("Loaded configs for %s", product)
-	audit.LogSystem("CONFIG_LOAD", "SUCCESS", map[string]interface{}{
-		"file":    path,
-		"product": product,
+	revision, err := source.ResolveRevision(ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	revisionMux.Lock()
+	defer revisionMux.Unlock()
+	if store, ok := revisionStores[revision]; ok {
+		return store, revision, nil
+	}
+
+	store, err := buildSnapshot(func() ([]string, error) {
+		return source.ListAt(revision)
+	}, func(id string) (*Document, error) {
+		return source.ReadAt(revision, id)
 	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(revisionStores) >= maxRevisionStores {
+		revisionStores = make(map[string]Store)
+	}
+	revisionStores[revision] = store
+	return store, revision, nil
+}
+
+// ReadStore reads every document of source into a Store without making it
+// the active source, publishing it to handlers or auditing it. It is meant
+// for command line tools.
+func ReadStore(source Source) (Store, error) {
+	return buildSnapshot(source.List, source.Read)
+}
+
+// buildSnapshot reads and resolves documents into a standalone snapshot.
+// Documents that cannot be read, invalid flags and values that cannot be
+// resolved are left out.
+func buildSnapshot(list func() ([]string, error), read func(id string) (*Document, error)) (*snapshot, error) {
+	ids, err := list()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]map[string]map[string]string)
+	canonical := make(map[string]map[string]map[string]string)
+	envFlags := make(map[string]map[string]map[string]flags.Flag)
+	for _, id := range ids {
+		doc, err := read(id)
+		if err != nil {
+			logger.Log.Printf("Failed to read %s: %v", id, err)
+			continue
+		}
+		for name, flag := range doc.Flags {
+			if flag.Validate() != nil {
+				delete(doc.Flags, name)
+			}
+		}
+		raw = withEnvironment(raw, doc.Product, doc.Environment, doc.Configs)
+		canonical = withEnvironment(canonical, doc.Product, doc.Environment, doc.Canonical)
+		envFlags = withEnvironment(envFlags, doc.Product, doc.Environment, doc.Flags)
+	}
+	resolved, _ := resolveStore(raw)
+
+	return &snapshot{raw: raw, resolved: resolved, canonical: canonical, flags: envFlags}, nil
+}
+
+// GetRevision returns the revision of the active source, or an empty string
+// when the source does not keep history.
+func GetRevision() string {
+	if source, ok := GetSource().(VersionedSource); ok {
+		return source.Revision()
+	}
+	return ""
 }
 
-func GetConfigs() map[string]map[string]string {
-	return configStore
+// GetSource returns the source configs were loaded from.
+func GetSource() Source {
+	mu.RLock()
+	defer mu.RUnlock()
+	return activeSource
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaskedValue replaces secret values in diffs.
+const MaskedValue = "********"
+
+// secretKeyPatterns are substrings of key names whose values are masked.
+var secretKeyPatterns = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "private_key", "credential"}
+
+// IsSecretKey reports whether the value of key must not be shown.
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range secretKeyPatterns {
+		if strings.Contains(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValueChange is a key whose value differs between two environments.
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Diff lists how the configs of one environment differ from another: keys
+// only in To are added, keys only in From are removed.
+type Diff struct {
+	Product string                 `json:"product"`
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+// Empty reports whether both environments hold the same configs.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffEnvironments compares two environments of a product in store. Values
+// of secret keys are masked. With raw set, values are compared as written
+// rather than resolved.
+func DiffEnvironments(store Store, product string, from string, to string, raw bool) (*Diff, error) {
+	lookup := store.Configs
+	if raw {
+		lookup = store.RawConfigs
+	}
+
+	fromConfigs, ok := lookup(product, from)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s not found", product, from)
+	}
+	toConfigs, ok := lookup(product, to)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s not found", product, to)
+	}
+
+	diff := &Diff{
+		Product: product,
+		From:    from,
+		To:      to,
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string]ValueChange),
+	}
+	for key, toValue := range toConfigs {
+		fromValue, exists := fromConfigs[key]
+		switch {
+		case !exists:
+			diff.Added[key] = maskValue(key, toValue)
+		case fromValue != toValue:
+			diff.Changed[key] = ValueChange{From: maskValue(key, fromValue), To: maskValue(key, toValue)}
+		}
+	}
+	for key, fromValue := range fromConfigs {
+		if _, exists := toConfigs[key]; !exists {
+			diff.Removed[key] = maskValue(key, fromValue)
+		}
+	}
+	return diff, nil
+}
+
+func maskValue(key string, value string) string {
+	if IsSecretKey(key) {
+		return MaskedValue
+	}
+	return value
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testStore builds a Store from raw values as the loader would.
+func testStore(raw map[string]map[string]map[string]string) Store {
+	resolved, _ := resolveStore(raw)
+	return &snapshot{raw: raw, resolved: resolved}
+}
+
+func TestDiffEnvironments(t *testing.T) {
+	store := testStore(merge(
+		envs("app", "staging", map[string]string{
+			"host":        "staging.internal",
+			"url":         "https://${host}",
+			"timeout":     "5",
+			"db_password": "old",
+			"only_from":   "x",
+			"same":        "1",
+		}),
+		envs("app", "production", map[string]string{
+			"host":        "prod.internal",
+			"url":         "https://${host}",
+			"timeout":     "5",
+			"db_password": "new",
+			"api_token":   "t",
+			"same":        "1",
+		}),
+		envs("app", "development", map[string]string{"same": "1"}),
+		envs("other", "development", map[string]string{"same": "1"}),
+	))
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		raw     bool
+		want    *Diff
+		wantErr string
+	}{
+		{
+			name: "resolved values",
+			from: "staging",
+			to:   "production",
+			want: &Diff{
+				Added:   map[string]string{"api_token": MaskedValue},
+				Removed: map[string]string{"only_from": "x"},
+				Changed: map[string]ValueChange{
+					"host":        {From: "staging.internal", To: "prod.internal"},
+					"url":         {From: "https://staging.internal", To: "https://prod.internal"},
+					"db_password": {From: MaskedValue, To: MaskedValue},
+				},
+			},
+		},
+		{
+			name: "values as written",
+			from: "staging",
+			to:   "production",
+			raw:  true,
+			want: &Diff{
+				Added:   map[string]string{"api_token": MaskedValue},
+				Removed: map[string]string{"only_from": "x"},
+				Changed: map[string]ValueChange{
+					"host":        {From: "staging.internal", To: "prod.internal"},
+					"db_password": {From: MaskedValue, To: MaskedValue},
+				},
+			},
+		},
+		{
+			name: "reversed",
+			from: "production",
+			to:   "staging",
+			raw:  true,
+			want: &Diff{
+				Added:   map[string]string{"only_from": "x"},
+				Removed: map[string]string{"api_token": MaskedValue},
+				Changed: map[string]ValueChange{
+					"host":        {From: "prod.internal", To: "staging.internal"},
+					"db_password": {From: MaskedValue, To: MaskedValue},
+				},
+			},
+		},
+		{
+			name: "identical",
+			from: "development",
+			to:   "development",
+			want: &Diff{Added: map[string]string{}, Removed: map[string]string{}, Changed: map[string]ValueChange{}},
+		},
+		{name: "missing source", from: "qa", to: "production", wantErr: "app/qa not found"},
+		{name: "missing target", from: "staging", to: "qa", wantErr: "app/qa not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffEnvironments(store, "app", tt.from, tt.to, tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("DiffEnvironments() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DiffEnvironments() error = %v", err)
+			}
+
+			tt.want.Product, tt.want.From, tt.want.To = "app", tt.from, tt.to
+			if !reflect.DeepEqual(diff, tt.want) {
+				t.Errorf("DiffEnvironments() = %+v, want %+v", diff, tt.want)
+			}
+			if diff.Empty() != (len(tt.want.Added)+len(tt.want.Removed)+len(tt.want.Changed) == 0) {
+				t.Errorf("Empty() = %v", diff.Empty())
+			}
+		})
+	}
+}
+
+func TestIsSecretKey(t *testing.T) {
+	tests := map[string]bool{
+		"db_password":     true,
+		"DB_PASSWD":       true,
+		"client_secret":   true,
+		"github.token":    true,
+		"stripe_api_key":  true,
+		"tls.private_key": true,
+		"aws_credentials": true,
+		"host":            false,
+		"token_ttl":       true,
+		"keyboard":        false,
+	}
+
+	for key, want := range tests {
+		if got := IsSecretKey(key); got != want {
+			t.Errorf("IsSecretKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSource reads documents from a directory laid out as
+// {product}/{environment}.yml, or {tenant}/{product}/{environment}.yml in
+// multi-tenant mode. Document IDs are file paths.
+type FileSource struct {
+	dir          string
+	watchMode    string
+	pollInterval time.Duration
+	autoCommit   bool
+}
+
+func NewFileSource(dir string, watchMode string, pollInterval time.Duration) *FileSource {
+	return &FileSource{dir: dir, watchMode: watchMode, pollInterval: pollInterval}
+}
+
+// EnableAutoCommit makes every Write commit the written file to the git
+// repository containing the config directory, authored by the caller.
+func (s *FileSource) EnableAutoCommit() error {
+	out, err := s.git("rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "true" {
+		return fmt.Errorf("%s is not inside a git working tree", s.dir)
+	}
+	s.autoCommit = true
+	return nil
+}
+
+func (s *FileSource) Name() string {
+	return "file:" + s.dir
+}
+
+func (s *FileSource) Dir() string {
+	return s.dir
+}
+
+func (s *FileSource) List() ([]string, error) {
+	var paths []string
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".yml" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+func (s *FileSource) Read(path string) (*Document, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid path structure")
+	}
+
+	// The product is the directory relative to the config root, which is
+	// "{tenant}/{product}" for tenant trees
+	product := filepath.Base(filepath.Dir(path))
+	if rel, err := filepath.Rel(s.dir, filepath.Dir(path)); err == nil && !strings.HasPrefix(rel, "..") && rel != "." {
+		product = filepath.ToSlash(rel)
+	}
+	env := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return parseDocument(product, env, bytes)
+}
+
+func (s *FileSource) FileInfo(path string) (string, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	hash, err := hashFile(path)
+	return hash, info.ModTime(), err
+}
+
+// Write replaces the document's file atomically. Unchanged values of the
+// previous file keep their spelling and comments, see marshalDocument.
+func (s *FileSource) Write(doc *Document, change Change) (string, error) {
+	path := filepath.Join(s.dir, doc.Product, doc.Environment+".yml")
+	previous, _ := os.ReadFile(path)
+	bytes, err := marshalDocument(doc, previous)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bytes, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if s.autoCommit {
+		if err := s.commit(path, change); err != nil {
+			return path, fmt.Errorf("commit %s: %w", path, err)
+		}
+	}
+	return path, nil
+}
+
+// commit records path, and nothing else that may be staged, as a commit
+// authored by the user who made the change. The committer is the server.
+func (s *FileSource) commit(path string, change Change) error {
+	// git runs in s.dir, which path already starts with
+	path, err := filepath.Rel(s.dir, path)
+	if err != nil {
+		return err
+	}
+	if _, err := s.git("add", "--", path); err != nil {
+		return err
+	}
+
+	// Skip writes that did not change the file's content
+	if _, err := s.git("diff", "--cached", "--quiet", "--", path); err == nil {
+		return nil
+	}
+
+	author := authorName(change.UserID)
+	if author == "" {
+		author = "SYSTEM"
+	}
+	email := author
+	if !strings.Contains(email, "@") {
+		email = author + "@simple-config-server"
+	}
+
+	message := change.Message
+	if message == "" {
+		message = "Update " + path
+	}
+
+	_, err = s.git("commit", "--quiet", "--no-verify",
+		"--author", fmt.Sprintf("%s <%s>", author, email),
+		"-m", message, "--", path)
+	return err
+}
+
+// authorName strips the characters that would let a user ID break out of
+// the "name <email>" form of --author.
+func authorName(userID string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		switch r {
+		case '<', '>', '\n', '\r':
+			return -1
+		}
+		return r
+	}, userID))
+}
+
+func (s *FileSource) git(args ...string) (string, error) {
+	return runGit(s.dir, args...)
+}
+
+func (s *FileSource) Watch(onChange func(path string)) {
+	WatchConfigDir(s.dir, s.watchMode, s.pollInterval, func(path string) {
+		if filepath.Ext(path) == ".yml" {
+			onChange(path)
+		}
+	})
+}
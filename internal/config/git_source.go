@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitSource reads documents straight from the object database of a local
+// git repository, bare or not, at the head of a branch. The working tree is
+// never read. Document IDs are paths inside the repository laid out as
+// [dir/][{tenant}/]{product}/{environment}.yml.
+type GitSource struct {
+	repo         string
+	branch       string
+	dir          string
+	pollInterval time.Duration
+
+	mu       sync.RWMutex
+	revision string
+}
+
+// NewGitSource opens repo at the head of branch. Only files below dir are
+// read; an empty dir means the repository root.
+func NewGitSource(repo string, branch string, dir string, pollInterval time.Duration) (*GitSource, error) {
+	s := &GitSource{
+		repo:         repo,
+		branch:       branch,
+		dir:          strings.Trim(path.Clean("/"+dir), "/"),
+		pollInterval: pollInterval,
+	}
+
+	revision, err := s.ResolveRevision(branch)
+	if err != nil {
+		return nil, err
+	}
+	s.revision = revision
+	return s, nil
+}
+
+func (s *GitSource) Name() string {
+	return "git:" + s.repo + "@" + s.branch
+}
+
+// Revision returns the commit documents are currently read from.
+func (s *GitSource) Revision() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+func (s *GitSource) List() ([]string, error) {
+	return s.ListAt(s.Revision())
+}
+
+func (s *GitSource) Read(id string) (*Document, error) {
+	return s.ReadAt(s.Revision(), id)
+}
+
+func (s *GitSource) Write(doc *Document, change Change) (string, error) {
+	return "", fmt.Errorf("%s is read-only", s.Name())
+}
+
+// ResolveRevision turns a commit SHA, tag or branch name into a full commit
+// SHA.
+func (s *GitSource) ResolveRevision(ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid revision %q", ref)
+	}
+	out, err := s.git("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %q", ref)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (s *GitSource) ListAt(revision string) ([]string, error) {
+	args := []string{"ls-tree", "-r", "--name-only", revision}
+	if s.dir != "" {
+		args = append(args, "--", s.dir)
+	}
+	out, err := s.git(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, name := range strings.Split(out, "\n") {
+		if path.Ext(name) == ".yml" && strings.Count(strings.TrimPrefix(name, s.dir+"/"), "/") >= 1 {
+			ids = append(ids, name)
+		}
+	}
+	return ids, nil
+}
+
+func (s *GitSource) ReadAt(revision string, id string) (*Document, error) {
+	out, err := s.blob(revision, id)
+	if err != nil {
+		return nil, err
+	}
+
+	product := strings.TrimPrefix(path.Dir(id), s.dir+"/")
+	if s.dir == "" {
+		product = path.Dir(id)
+	}
+	env := strings.TrimSuffix(path.Base(id), path.Ext(id))
+	return parseDocument(product, env, out)
+}
+
+// blob returns the content of id at revision, or an error wrapping
+// fs.ErrNotExist when revision has no such file.
+func (s *GitSource) blob(revision string, id string) ([]byte, error) {
+	out, err := s.git("show", revision+":"+id)
+	if err != nil {
+		if listed, lsErr := s.git("ls-tree", "--name-only", revision, "--", id); lsErr == nil && listed == "" {
+			return nil, fmt.Errorf("%s at %s: %w", id, revision, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// changedDocuments lists the documents added, modified or removed between
+// two revisions. Both the old and the new path of a renamed document are
+// listed.
+func (s *GitSource) changedDocuments(from string, to string) ([]string, error) {
+	args := []string{"diff", "-z", "--name-status", "--find-renames", "--diff-filter=ADMR", from, to}
+	if s.dir != "" {
+		args = append(args, "--", s.dir)
+	}
+	out, err := s.git(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are a status followed by one path, or by two for renames
+	var ids []string
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.HasPrefix(fields[i], "R") && i+2 < len(fields) {
+			i++
+			ids = append(ids, fields[i])
+		}
+		ids = append(ids, fields[i+1])
+	}
+
+	var documents []string
+	for _, id := range ids {
+		if path.Ext(id) == ".yml" {
+			documents = append(documents, id)
+		}
+	}
+	return documents, nil
+}
+
+// Watch polls the branch for new commits and reports the documents each new
+// commit added, modified or removed. The new commit is only served once the
+// documents it changed could be read from it; until then it is retried on
+// every poll.
+func (s *GitSource) Watch(onChange func(id string)) {
+	interval := s.pollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	logger.Log.Printf("Polling git branch %s of %s every %s", s.branch, s.repo, interval)
+	audit.LogSystem("CONFIG_WATCH", "POLLING", map[string]interface{}{
+		"source":   s.Name(),
+		"interval": interval.String(),
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		previous := s.Revision()
+		revision, err := s.ResolveRevision(s.branch)
+		if err != nil {
+			logger.Log.Printf("Error polling git branch %s: %v", s.branch, err)
+			sourceFailed(err)
+			continue
+		}
+		sourceRead()
+		if revision == previous {
+			continue
+		}
+
+		changed, err := s.changedDocuments(previous, revision)
+		if err != nil {
+			logger.Log.Printf("Error diffing git revisions %s..%s: %v", previous, revision, err)
+			continue
+		}
+		if err := s.fetch(revision, changed); err != nil {
+			logger.Log.Printf("Error reading git revision %s: %v", revision, err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.revision = revision
+		s.mu.Unlock()
+
+		logger.Log.Printf("New config revision %s", revision)
+		audit.LogSystem("CONFIG_WATCH", "NEW_REVISION", map[string]interface{}{
+			"source":   s.Name(),
+			"previous": previous,
+			"revision": revision,
+		})
+
+		for _, id := range changed {
+			onChange(id)
+		}
+	}
+}
+
+// fetch reads the files ids at revision, so that a failure to read the
+// revision is noticed before switching to it. Removed files are skipped.
+func (s *GitSource) fetch(revision string, ids []string) error {
+	for _, id := range ids {
+		if _, err := s.blob(revision, id); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GitSource) git(args ...string) (string, error) {
+	return runGit(s.repo, args...)
+}
+
+// runGit runs a git command in dir and returns its standard output. Commits
+// made through it are attributed to the server as committer.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_COMMITTER_NAME=Simple Config Server",
+		"GIT_COMMITTER_EMAIL=config-server@simple-config-server",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
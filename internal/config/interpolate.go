@@ -0,0 +1,209 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Placeholders supported in config values:
+//
+//	${key}                  another key of the same product and environment
+//	${env:NAME}             an environment variable of the server process,
+//	                        if allowed by AllowEnvPlaceholders
+//	${ref:product/env/key}  a key of any loaded product and environment of
+//	                        the same tenant
+//	$$                      a literal dollar sign
+const (
+	envPrefix = "env:"
+	refPrefix = "ref:"
+)
+
+// envPlaceholders lists the environment variables ${env:NAME} may read. An
+// entry ending in * allows every name with that prefix. Nothing is allowed
+// by default, so that a value cannot read secrets such as ADMIN_TOKEN from
+// the server process.
+var envPlaceholders []string
+
+// AllowEnvPlaceholders sets the environment variable names, or name
+// prefixes ending in *, that ${env:NAME} placeholders may read. It must be
+// called before configs are loaded.
+func AllowEnvPlaceholders(names []string) {
+	envPlaceholders = nil
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			envPlaceholders = append(envPlaceholders, name)
+		}
+	}
+}
+
+func envPlaceholderAllowed(name string) bool {
+	for _, allowed := range envPlaceholders {
+		if allowed == name {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEnvPlaceholder reports whether value contains an ${env:NAME}
+// placeholder. Such values are only accepted from config files, never from
+// API writes.
+func HasEnvPlaceholder(value string) bool {
+	return strings.Contains(value, "${"+envPrefix)
+}
+
+type refKey struct {
+	product string
+	env     string
+	key     string
+}
+
+func (r refKey) String() string {
+	return r.product + "/" + r.env + "/" + r.key
+}
+
+type resolver struct {
+	raw      map[string]map[string]map[string]string
+	resolved map[refKey]string
+	failed   map[refKey]error
+	visiting map[refKey]bool
+	stack    []refKey
+}
+
+// resolveStore expands the placeholders of every value in raw. Values that
+// cannot be resolved are left out of the result and reported as errors.
+func resolveStore(raw map[string]map[string]map[string]string) (map[string]map[string]map[string]string, []error) {
+	r := &resolver{
+		raw:      raw,
+		resolved: make(map[refKey]string),
+		failed:   make(map[refKey]error),
+		visiting: make(map[refKey]bool),
+	}
+
+	result := make(map[string]map[string]map[string]string)
+	var errs []error
+	for product, envs := range raw {
+		result[product] = make(map[string]map[string]string)
+		for env, configs := range envs {
+			values := make(map[string]string)
+			for key := range configs {
+				ref := refKey{product, env, key}
+				value, err := r.resolve(ref)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+					continue
+				}
+				values[key] = value
+			}
+			result[product][env] = values
+		}
+	}
+	return result, errs
+}
+
+func (r *resolver) resolve(ref refKey) (string, error) {
+	if value, ok := r.resolved[ref]; ok {
+		return value, nil
+	}
+	if err, ok := r.failed[ref]; ok {
+		return "", err
+	}
+
+	if r.visiting[ref] {
+		cycle := make([]string, 0, len(r.stack)+1)
+		for _, k := range r.stack {
+			cycle = append(cycle, k.String())
+		}
+		cycle = append(cycle, ref.String())
+		return "", fmt.Errorf("reference cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	raw, ok := r.raw[ref.product][ref.env][ref.key]
+	if !ok {
+		return "", fmt.Errorf("unknown key %s", ref)
+	}
+
+	r.visiting[ref] = true
+	r.stack = append(r.stack, ref)
+	value, err := r.expand(ref, raw)
+	r.stack = r.stack[:len(r.stack)-1]
+	delete(r.visiting, ref)
+
+	if err != nil {
+		r.failed[ref] = err
+		return "", err
+	}
+	r.resolved[ref] = value
+	return value, nil
+}
+
+func (r *resolver) expand(ref refKey, value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated placeholder in %q", value)
+			}
+			expr := value[i+2 : i+2+end]
+			replacement, err := r.lookup(ref, expr)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(replacement)
+			i += end + 2
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func (r *resolver) lookup(ref refKey, expr string) (string, error) {
+	switch {
+	case strings.HasPrefix(expr, envPrefix):
+		name := strings.TrimPrefix(expr, envPrefix)
+		if !envPlaceholderAllowed(name) {
+			return "", fmt.Errorf("unresolved reference ${%s}: environment variable not allowed", expr)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("unresolved reference ${%s}: environment variable not set", expr)
+		}
+		return value, nil
+	case strings.HasPrefix(expr, refPrefix):
+		parts := strings.Split(strings.TrimPrefix(expr, refPrefix), "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return "", fmt.Errorf("invalid reference ${%s}: expected product/env/key", expr)
+		}
+		// References never leave the tenant of the referring product
+		target := refKey{parts[0], parts[1], parts[2]}
+		if i := strings.LastIndex(ref.product, "/"); i >= 0 {
+			target.product = ref.product[:i+1] + target.product
+		}
+		return r.lookupKey(expr, target)
+	case expr == "":
+		return "", fmt.Errorf("empty placeholder ${}")
+	default:
+		return r.lookupKey(expr, refKey{ref.product, ref.env, expr})
+	}
+}
+
+func (r *resolver) lookupKey(expr string, target refKey) (string, error) {
+	if _, ok := r.raw[target.product][target.env][target.key]; !ok {
+		return "", fmt.Errorf("unresolved reference ${%s}: %s not found", expr, target)
+	}
+	return r.resolve(target)
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveStore(t *testing.T) {
+	t.Setenv("SCS_TEST_HOST", "db.internal")
+	t.Setenv("SCS_SECRET", "hunter2")
+	AllowEnvPlaceholders([]string{"SCS_TEST_*", "OTHER"})
+	t.Cleanup(func() { AllowEnvPlaceholders(nil) })
+
+	tests := []struct {
+		name    string
+		raw     map[string]map[string]map[string]string
+		want    map[string]string
+		wantErr map[string]string
+	}{
+		{
+			name: "same environment",
+			raw: envs("app", "dev", map[string]string{
+				"host": "localhost",
+				"url":  "http://${host}:8080",
+			}),
+			want: map[string]string{"app/dev/host": "localhost", "app/dev/url": "http://localhost:8080"},
+		},
+		{
+			name: "environment variable and escaped dollar",
+			raw: envs("app", "dev", map[string]string{
+				"dsn":   "postgres://${env:SCS_TEST_HOST}/app",
+				"price": "$$5 and $",
+			}),
+			want: map[string]string{"app/dev/dsn": "postgres://db.internal/app", "app/dev/price": "$5 and $"},
+		},
+		{
+			name: "reference to another product",
+			raw: merge(
+				envs("app", "dev", map[string]string{"db": "${ref:shared/dev/db}"}),
+				envs("shared", "dev", map[string]string{"db": "postgres"}),
+			),
+			want: map[string]string{"app/dev/db": "postgres", "shared/dev/db": "postgres"},
+		},
+		{
+			name: "references stay within the tenant",
+			raw: merge(
+				envs("acme/app", "dev", map[string]string{"db": "${ref:shared/dev/db}"}),
+				envs("shared", "dev", map[string]string{"db": "postgres"}),
+			),
+			want:    map[string]string{"shared/dev/db": "postgres"},
+			wantErr: map[string]string{"acme/app/dev/db": "acme/shared/dev/db not found"},
+		},
+		{
+			name: "self reference",
+			raw:  envs("app", "dev", map[string]string{"a": "${a}"}),
+			wantErr: map[string]string{
+				"app/dev/a": "reference cycle: app/dev/a -> app/dev/a",
+			},
+		},
+		{
+			name: "cycle across keys",
+			raw: envs("app", "dev", map[string]string{
+				"a":    "${b}",
+				"b":    "${c}",
+				"c":    "${a}",
+				"free": "ok",
+			}),
+			want: map[string]string{"app/dev/free": "ok"},
+			wantErr: map[string]string{
+				"app/dev/a": "reference cycle",
+				"app/dev/b": "reference cycle",
+				"app/dev/c": "reference cycle",
+			},
+		},
+		{
+			name: "cycle across products",
+			raw: merge(
+				envs("app", "dev", map[string]string{"a": "${ref:shared/dev/b}"}),
+				envs("shared", "dev", map[string]string{"b": "${ref:app/dev/a}"}),
+			),
+			wantErr: map[string]string{
+				"app/dev/a":    "reference cycle",
+				"shared/dev/b": "reference cycle",
+			},
+		},
+		{
+			name: "value depending on a cycle",
+			raw: envs("app", "dev", map[string]string{
+				"a":   "${b}",
+				"b":   "${a}",
+				"use": "x${a}",
+			}),
+			wantErr: map[string]string{
+				"app/dev/a":   "reference cycle",
+				"app/dev/b":   "reference cycle",
+				"app/dev/use": "reference cycle",
+			},
+		},
+		{
+			name: "diamond is not a cycle",
+			raw: envs("app", "dev", map[string]string{
+				"top":   "${left}${right}",
+				"left":  "${base}",
+				"right": "${base}",
+				"base":  "x",
+			}),
+			want: map[string]string{
+				"app/dev/top":   "xx",
+				"app/dev/left":  "x",
+				"app/dev/right": "x",
+				"app/dev/base":  "x",
+			},
+		},
+		{
+			name: "unknown key and bad placeholders",
+			raw: envs("app", "dev", map[string]string{
+				"missing": "${nope}",
+				"open":    "${host",
+				"empty":   "${}",
+				"ref":     "${ref:shared/db}",
+				"env":     "${env:SCS_TEST_UNSET}",
+				"secret":  "${env:SCS_SECRET}",
+			}),
+			wantErr: map[string]string{
+				"app/dev/missing": "app/dev/nope not found",
+				"app/dev/open":    "unterminated placeholder",
+				"app/dev/empty":   "empty placeholder",
+				"app/dev/ref":     "expected product/env/key",
+				"app/dev/env":     "environment variable not set",
+				"app/dev/secret":  "environment variable not allowed",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, errs := resolveStore(tt.raw)
+
+			got := make(map[string]string)
+			for product, envs := range resolved {
+				for env, configs := range envs {
+					for key, value := range configs {
+						got[product+"/"+env+"/"+key] = value
+					}
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("resolved %v, want %v", got, tt.want)
+			}
+			for ref, want := range tt.want {
+				if got[ref] != want {
+					t.Errorf("%s = %q, want %q", ref, got[ref], want)
+				}
+			}
+
+			if len(errs) != len(tt.wantErr) {
+				t.Errorf("got %d errors %v, want %d", len(errs), errs, len(tt.wantErr))
+			}
+			for ref, want := range tt.wantErr {
+				found := false
+				for _, err := range errs {
+					if strings.HasPrefix(err.Error(), ref+": ") && strings.Contains(err.Error(), want) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("no error for %s containing %q in %v", ref, want, errs)
+				}
+			}
+		})
+	}
+}
+
+func envs(product string, env string, configs map[string]string) map[string]map[string]map[string]string {
+	return map[string]map[string]map[string]string{product: {env: configs}}
+}
+
+func merge(stores ...map[string]map[string]map[string]string) map[string]map[string]map[string]string {
+	merged := make(map[string]map[string]map[string]string)
+	for _, store := range stores {
+		for product, envs := range store {
+			if merged[product] == nil {
+				merged[product] = make(map[string]map[string]string)
+			}
+			for env, configs := range envs {
+				merged[product][env] = configs
+			}
+		}
+	}
+	return merged
+}
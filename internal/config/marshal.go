@@ -0,0 +1,159 @@
+package config
+
+import (
+	"bytes"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// marshalDocument encodes a document in the same YAML layout as the files
+// in the config directory. previous is the stored YAML of the document, if
+// any. Its nodes are edited rather than replaced, so that unchanged values
+// keep their spelling, type and comments: enabled: yes stays a boolean
+// instead of becoming the string "yes". Changed values are written as
+// strings, unless doc.Canonical marks them as typed scalars.
+func marshalDocument(doc *Document, previous []byte) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	var file yaml.Node
+	if yaml.Unmarshal(previous, &file) == nil && len(file.Content) == 1 && file.Content[0].Kind == yaml.MappingNode {
+		root = file.Content[0]
+	}
+
+	configs, err := configsNode(doc, mappingValue(root, "configs"))
+	if err != nil {
+		return nil, err
+	}
+	setMappingValue(root, "configs", configs)
+
+	var old Config
+	yamlv2.Unmarshal(previous, &old)
+	if len(doc.Flags) == 0 {
+		deleteMappingKey(root, "flags")
+	} else if flagsNode := mappingValue(root, "flags"); flagsNode == nil || !sameYAML(old.Flags, doc.Flags) {
+		node, err := encodeNode(doc.Flags)
+		if err != nil {
+			return nil, err
+		}
+		setMappingValue(root, "flags", node)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// configsNode returns the configs mapping of doc. Entries of previous whose
+// value did not change are kept as they are, in their order; new keys are
+// appended in name order.
+func configsNode(doc *Document, previous *yaml.Node) (*yaml.Node, error) {
+	configs := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	written := make(map[string]bool)
+	if previous != nil && previous.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(previous.Content); i += 2 {
+			key, value := previous.Content[i], previous.Content[i+1]
+			newValue, exists := doc.Configs[key.Value]
+			if !exists || written[key.Value] {
+				continue
+			}
+			written[key.Value] = true
+			if !spells(value, newValue) {
+				var err error
+				if value, err = configValueNode(doc, key.Value); err != nil {
+					return nil, err
+				}
+			}
+			configs.Content = append(configs.Content, key, value)
+		}
+	}
+
+	for _, key := range sortedConfigKeys(doc.Configs) {
+		if written[key] {
+			continue
+		}
+		keyNode, err := encodeNode(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := configValueNode(doc, key)
+		if err != nil {
+			return nil, err
+		}
+		configs.Content = append(configs.Content, keyNode, value)
+	}
+	return configs, nil
+}
+
+// configValueNode returns the node of a changed or added config value.
+func configValueNode(doc *Document, key string) (*yaml.Node, error) {
+	value := doc.Configs[key]
+	if _, typed := doc.Canonical[key]; typed {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: value}, nil
+	}
+	return encodeNode(value)
+}
+
+// spells reports whether node is a scalar that is read as value.
+func spells(node *yaml.Node, value string) bool {
+	if node.Kind != yaml.ScalarNode {
+		return false
+	}
+	return node.Value == value || node.Tag == "!!null" && value == ""
+}
+
+// encodeNode encodes v as yaml.v2 would, which is also how documents are
+// parsed. yaml.v3 alone would leave strings such as "yes" unquoted.
+func encodeNode(v interface{}) (*yaml.Node, error) {
+	encoded, err := yamlv2.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var file yaml.Node
+	if err := yaml.Unmarshal(encoded, &file); err != nil {
+		return nil, err
+	}
+	return file.Content[0], nil
+}
+
+// sameYAML reports whether a and b encode to the same YAML.
+func sameYAML(a interface{}, b interface{}) bool {
+	encodedA, errA := yamlv2.Marshal(a)
+	encodedB, errB := yamlv2.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	mapping.Content = append(mapping.Content, keyNode, value)
+}
+
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
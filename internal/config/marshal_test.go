@@ -0,0 +1,86 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const typedDocument = `configs:
+  # Comments survive writes
+  enabled: yes
+  port: 8080
+  flag: true
+  hex: 0x1F
+  quoted: "yes"
+  empty: ~
+  text: plain
+`
+
+func TestMarshalDocumentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		edit     func(doc *Document)
+		contains []string
+	}{
+		{
+			name:     "unchanged",
+			previous: typedDocument,
+			edit:     func(doc *Document) {},
+			contains: []string{"# Comments survive writes", "enabled: yes", "port: 8080", "hex: 0x1F", `quoted: "yes"`, "empty: ~"},
+		},
+		{
+			name:     "edited",
+			previous: typedDocument,
+			edit: func(doc *Document) {
+				doc.Configs["port"] = "9090"
+				doc.Configs["added"] = "on"
+				delete(doc.Configs, "text")
+			},
+			contains: []string{"enabled: yes", `port: "9090"`, `added: "on"`, "hex: 0x1F"},
+		},
+		{
+			name:     "without the previous file",
+			edit:     func(doc *Document) {},
+			contains: []string{"enabled: yes", "hex: 0x1F", `quoted: "yes"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseDocument("app", "dev", []byte(typedDocument))
+			if err != nil {
+				t.Fatalf("parseDocument() error = %v", err)
+			}
+			tt.edit(doc)
+
+			bytes, err := marshalDocument(doc, []byte(tt.previous))
+			if err != nil {
+				t.Fatalf("marshalDocument() error = %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(string(bytes), want) {
+					t.Errorf("output does not contain %q:\n%s", want, bytes)
+				}
+			}
+
+			got, err := parseDocument("app", "dev", bytes)
+			if err != nil {
+				t.Fatalf("parseDocument() of output error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Configs, doc.Configs) {
+				t.Errorf("Configs = %v, want %v", got.Configs, doc.Configs)
+			}
+			want := make(map[string]string)
+			for key, spelling := range doc.Canonical {
+				if _, exists := doc.Configs[key]; exists {
+					want[key] = spelling
+				}
+			}
+			if !reflect.DeepEqual(got.Canonical, want) {
+				t.Errorf("Canonical = %v, want %v", got.Canonical, want)
+			}
+		})
+	}
+}
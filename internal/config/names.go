@@ -0,0 +1,29 @@
+package config
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// NamePattern restricts product, environment and flag names; KeyPattern
+// restricts keys, which the HTTP API also accepts as glob patterns. Both
+// APIs check names against them.
+var (
+	NamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	KeyPattern  = regexp.MustCompile(`^[A-Za-z0-9_.*?\[\]^-]+$`)
+)
+
+// Environments lists the environments configs are served for.
+var Environments = []string{"development", "staging", "production"}
+
+// IsSupportedEnv reports whether env is one of Environments.
+func IsSupportedEnv(env string) bool {
+	return slices.Contains(Environments, env)
+}
+
+// IsKeyName reports whether key is a valid key that is not a glob pattern,
+// so that it names a single key.
+func IsKeyName(key string) bool {
+	return KeyPattern.MatchString(key) && !strings.ContainsAny(key, "*?[")
+}
@@ -0,0 +1,24 @@
+package config
+
+import "testing"
+
+func TestIsKeyName(t *testing.T) {
+	tests := map[string]bool{
+		"db_host":     true,
+		"api.url-v2":  true,
+		"a^b":         true,
+		"db_*":        false,
+		"db?":         false,
+		"[ab]":        false,
+		"":            false,
+		"with space":  false,
+		"../etc":      false,
+		"db_host/raw": false,
+	}
+
+	for key, want := range tests {
+		if got := IsKeyName(key); got != want {
+			t.Errorf("IsKeyName(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
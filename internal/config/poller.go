@@ -0,0 +1,111 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/logger"
+	"time"
+)
+
+const DefaultPollInterval = 5 * time.Second
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// PollConfigDir periodically scans the config directory and calls onChange
+// for files whose content changed or that were removed. It is used where fsnotify events never
+// fire, such as NFS mounts.
+func PollConfigDir(configDir string, interval time.Duration, onChange func(path string)) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	logger.Log.Printf("Polling config directory %s every %s", configDir, interval)
+	audit.LogSystem("CONFIG_WATCH", "POLLING", map[string]interface{}{
+		"dir":      configDir,
+		"interval": interval.String(),
+	})
+
+	states := scanConfigDir(configDir, nil, onChange)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		states = scanConfigDir(configDir, states, onChange)
+	}
+}
+
+// scanConfigDir snapshots every config file under configDir. Files that are
+// new, whose content differs from the previous snapshot or that are no
+// longer there are passed to onChange; a nil previous snapshot only records
+// the initial state.
+func scanConfigDir(configDir string, previous map[string]fileState, onChange func(path string)) map[string]fileState {
+	current := make(map[string]fileState)
+
+	err := filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || filepath.Ext(path) != ".yml" {
+			return nil
+		}
+
+		state := fileState{modTime: info.ModTime(), size: info.Size()}
+		old, seen := previous[path]
+		if seen && old.modTime.Equal(state.modTime) && old.size == state.size {
+			current[path] = old
+			return nil
+		}
+
+		hash, err := hashFile(path)
+		if err != nil {
+			logger.Log.Printf("Error hashing config file %s: %v", path, err)
+			return nil
+		}
+		state.hash = hash
+		current[path] = state
+
+		if previous != nil && (!seen || old.hash != hash) {
+			logger.Log.Printf("Config file changed: %s", path)
+			onChange(path)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Printf("Error polling config directory: %v", err)
+		sourceFailed(err)
+		if previous != nil {
+			return previous
+		}
+		return current
+	}
+
+	for path := range previous {
+		if _, exists := current[path]; !exists {
+			logger.Log.Printf("Config file removed: %s", path)
+			onChange(path)
+		}
+	}
+
+	sourceRead()
+	return current
+}
+
+func hashFile(path string) (string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestScanConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("app/staging.yml", "configs: {}\n")
+	write("app/production.yml", "configs: {}\n")
+	write("app/development.yml", "configs: {}\n")
+
+	var changed []string
+	onChange := func(path string) {
+		rel, _ := filepath.Rel(dir, path)
+		changed = append(changed, filepath.ToSlash(rel))
+	}
+
+	states := scanConfigDir(dir, nil, onChange)
+	if len(changed) != 0 {
+		t.Errorf("initial scan reported %v", changed)
+	}
+
+	write("app/staging.yml", "configs:\n  a: changed\n")
+	write("app/qa.yml", "configs: {}\n")
+	if err := os.Remove(filepath.Join(dir, "app/production.yml")); err != nil {
+		t.Fatal(err)
+	}
+	scanConfigDir(dir, states, onChange)
+
+	sort.Strings(changed)
+	if want := []string{"app/production.yml", "app/qa.yml", "app/staging.yml"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("reported %v, want %v", changed, want)
+	}
+}
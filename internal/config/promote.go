@@ -0,0 +1,178 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrPlanStale is returned by ApplyPromotion when the environments changed
+// after the plan was generated.
+var ErrPlanStale = errors.New("configs changed since the plan was generated")
+
+// Plan is the set of values a promotion copies from one environment of a
+// product to another. Values are copied as written, placeholders included.
+// Changes holds the current and promoted value of every key that differs,
+// with secret values masked; keys that already match are left out.
+type Plan struct {
+	ID      string                 `json:"id"`
+	Product string                 `json:"product"`
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Keys    []string               `json:"keys,omitempty"`
+	Changes map[string]ValueChange `json:"changes"`
+
+	values map[string]string
+}
+
+// Empty reports whether applying the plan would change nothing.
+func (p *Plan) Empty() bool {
+	return len(p.values) == 0
+}
+
+// ChangedKeys lists the keys the plan sets, in name order.
+func (p *Plan) ChangedKeys() []string {
+	keys := make([]string, 0, len(p.values))
+	for key := range p.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Values returns the values the plan sets, unmasked and as written.
+func (p *Plan) Values() map[string]string {
+	values := make(map[string]string, len(p.values))
+	for key, value := range p.values {
+		values[key] = value
+	}
+	return values
+}
+
+// PlanPromotion plans copying keys, or every key when keys is empty, from
+// one environment of product to another in store. The target environment
+// does not need to exist yet. Keys only present in the target are kept.
+// Values with an ${env:NAME} placeholder are refused with ErrEnvPlaceholder.
+func PlanPromotion(store Store, product string, from string, to string, keys []string) (*Plan, error) {
+	if from == to {
+		return nil, fmt.Errorf("cannot promote %s/%s to itself", product, from)
+	}
+	fromConfigs, ok := store.RawConfigs(product, from)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s not found", product, from)
+	}
+	toConfigs, _ := store.RawConfigs(product, to)
+
+	selected := keys
+	if len(selected) == 0 {
+		selected = make([]string, 0, len(fromConfigs))
+		for key := range fromConfigs {
+			selected = append(selected, key)
+		}
+	}
+
+	plan := &Plan{
+		Product: product,
+		From:    from,
+		To:      to,
+		Keys:    keys,
+		Changes: make(map[string]ValueChange),
+		values:  make(map[string]string),
+	}
+	for _, key := range selected {
+		value, exists := fromConfigs[key]
+		if !exists {
+			return nil, fmt.Errorf("key %s not found in %s/%s", key, product, from)
+		}
+		if oldValue, exists := toConfigs[key]; exists && oldValue == value {
+			continue
+		}
+		if HasEnvPlaceholder(value) {
+			return nil, fmt.Errorf("key %s: %w", key, ErrEnvPlaceholder)
+		}
+		plan.values[key] = value
+		plan.Changes[key] = ValueChange{From: maskValue(key, toConfigs[key]), To: maskValue(key, value)}
+	}
+	plan.ID = planID(plan, toConfigs)
+	return plan, nil
+}
+
+// planID fingerprints the target environment and the promoted values, so
+// that a plan can only be applied to the state it was generated from.
+func planID(plan *Plan, toConfigs map[string]string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", plan.Product, plan.From, plan.To)
+	for _, key := range sortedConfigKeys(toConfigs) {
+		fmt.Fprintf(hash, "%s=%s\x00", key, toConfigs[key])
+	}
+	hash.Write([]byte{0})
+	for _, key := range sortedConfigKeys(plan.values) {
+		fmt.Fprintf(hash, "%s=%s\x00", key, plan.values[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:24]
+}
+
+func sortedConfigKeys(configs map[string]string) []string {
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyPromotion writes the plan with the given ID to the target
+// environment in source, which is loaded afterwards if it is the active
+// source. The plan is generated again from the documents as stored in
+// source, rather than as last loaded, and refused with ErrPlanStale unless
+// it still has the same ID, so that edits not picked up by the watcher yet
+// are not overwritten. The target document is replaced in a single write.
+func ApplyPromotion(source Source, product string, from string, to string, keys []string, id string, change Change) (*Plan, error) {
+	editMux.Lock()
+	defer editMux.Unlock()
+
+	store, err := ReadStore(source)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := PlanPromotion(store, product, from, to, keys)
+	if err != nil {
+		return nil, err
+	}
+	if plan.ID != id {
+		return plan, ErrPlanStale
+	}
+	if plan.Empty() {
+		return plan, nil
+	}
+
+	// The store leaves out invalid flags, which the write must keep
+	target, err := readStoredDocument(source, product, to)
+	if err != nil {
+		return plan, err
+	}
+	configs := make(map[string]string)
+	for key, value := range target.Configs {
+		configs[key] = value
+	}
+	for key, value := range plan.values {
+		configs[key] = value
+	}
+	// Promoted typed scalars, e.g. enabled: yes, are written as such
+	canonical := make(map[string]string)
+	fromCanonical := store.CanonicalConfigs(product, from)
+	for key := range plan.values {
+		if spelling, typed := fromCanonical[key]; typed {
+			canonical[key] = spelling
+		}
+	}
+
+	if change.Message == "" {
+		change.Message = fmt.Sprintf("Promote %s/%s to %s: %s", product, from, to, strings.Join(plan.ChangedKeys(), ", "))
+	}
+	doc := &Document{Product: product, Environment: to, Configs: configs, Flags: target.Flags, Canonical: canonical}
+	return plan, writeDocument(source, doc, change)
+}
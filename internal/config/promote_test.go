@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanPromotion(t *testing.T) {
+	store := testStore(merge(
+		envs("app", "staging", map[string]string{
+			"host":        "staging.internal",
+			"url":         "https://${host}",
+			"timeout":     "5",
+			"db_password": "new",
+		}),
+		envs("app", "production", map[string]string{
+			"host":        "prod.internal",
+			"url":         "https://${host}",
+			"timeout":     "10",
+			"db_password": "old",
+			"only_target": "kept",
+		}),
+	))
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		keys    []string
+		want    map[string]ValueChange
+		wantErr string
+	}{
+		{
+			name: "every key",
+			from: "staging",
+			to:   "production",
+			want: map[string]ValueChange{
+				"host":        {From: "prod.internal", To: "staging.internal"},
+				"timeout":     {From: "10", To: "5"},
+				"db_password": {From: MaskedValue, To: MaskedValue},
+			},
+		},
+		{
+			name: "selected keys",
+			from: "staging",
+			to:   "production",
+			keys: []string{"timeout", "url"},
+			want: map[string]ValueChange{"timeout": {From: "10", To: "5"}},
+		},
+		{
+			name: "new environment",
+			from: "production",
+			to:   "development",
+			keys: []string{"host", "only_target"},
+			want: map[string]ValueChange{
+				"host":        {From: "", To: "prod.internal"},
+				"only_target": {From: "", To: "kept"},
+			},
+		},
+		{
+			name: "nothing to change",
+			from: "staging",
+			to:   "production",
+			keys: []string{"url"},
+			want: map[string]ValueChange{},
+		},
+		{name: "same environment", from: "staging", to: "staging", wantErr: "cannot promote app/staging to itself"},
+		{name: "missing source", from: "qa", to: "production", wantErr: "app/qa not found"},
+		{name: "missing key", from: "staging", to: "production", keys: []string{"host", "only_target"}, wantErr: "key only_target not found in app/staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := PlanPromotion(store, "app", tt.from, tt.to, tt.keys)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("PlanPromotion() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanPromotion() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(plan.Changes, tt.want) {
+				t.Errorf("Changes = %v, want %v", plan.Changes, tt.want)
+			}
+			if plan.Empty() != (len(tt.want) == 0) {
+				t.Errorf("Empty() = %v with changes %v", plan.Empty(), plan.Changes)
+			}
+			// Values are promoted as written, placeholders included
+			for _, key := range plan.ChangedKeys() {
+				want, _ := store.RawConfigs("app", tt.from)
+				if plan.values[key] != want[key] {
+					t.Errorf("promotes %s = %q, want %q", key, plan.values[key], want[key])
+				}
+			}
+			if len(plan.ID) != 24 {
+				t.Errorf("ID = %q, want 24 hex characters", plan.ID)
+			}
+		})
+	}
+}
+
+func TestPlanPromotionID(t *testing.T) {
+	staging := map[string]string{"host": "staging.internal", "timeout": "5"}
+	production := map[string]string{"host": "prod.internal", "timeout": "10"}
+	plan := func(production map[string]string, keys ...string) string {
+		t.Helper()
+		store := testStore(merge(envs("app", "staging", staging), envs("app", "production", production)))
+		p, err := PlanPromotion(store, "app", "staging", "production", keys)
+		if err != nil {
+			t.Fatalf("PlanPromotion() error = %v", err)
+		}
+		return p.ID
+	}
+
+	id := plan(production)
+	tests := []struct {
+		name string
+		id   string
+		same bool
+	}{
+		{name: "same state", id: plan(map[string]string{"timeout": "10", "host": "prod.internal"}), same: true},
+		{name: "target value changed", id: plan(map[string]string{"host": "prod.internal", "timeout": "20"})},
+		{name: "key added to target", id: plan(map[string]string{"host": "prod.internal", "timeout": "10", "extra": "1"})},
+		{name: "other keys selected", id: plan(production, "host")},
+	}
+
+	for _, tt := range tests {
+		if (tt.id == id) != tt.same {
+			t.Errorf("%s: ID %s, first plan %s, want same = %v", tt.name, tt.id, id, tt.same)
+		}
+	}
+}
+
+func TestPlanPromotionEnvPlaceholder(t *testing.T) {
+	store := testStore(merge(
+		envs("app", "staging", map[string]string{"secret": "${env:JWT_SECRET}", "host": "${env:HOSTNAME}"}),
+		envs("app", "production", map[string]string{"host": "${env:HOSTNAME}"}),
+	))
+
+	// Unchanged values are not written, so they are not refused
+	if _, err := PlanPromotion(store, "app", "staging", "production", []string{"host"}); err != nil {
+		t.Errorf("PlanPromotion() error = %v", err)
+	}
+	if _, err := PlanPromotion(store, "app", "staging", "production", nil); !errors.Is(err, ErrEnvPlaceholder) {
+		t.Errorf("PlanPromotion() error = %v, want %v", err, ErrEnvPlaceholder)
+	}
+}
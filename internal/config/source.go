@@ -0,0 +1,102 @@
+package config
+
+import (
+	"time"
+
+	"simpleConfigServer/internal/flags"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Document holds the configs and flags of one product environment, i.e. the
+// content of one {product}/{environment}.yml file.
+type Document struct {
+	Product     string
+	Environment string
+	Configs     map[string]string
+	Flags       map[string]flags.Flag
+	// Canonical holds the single spelling of the configs that are typed
+	// scalars, see canonicalConfigs. It is derived when parsing; on writes
+	// it marks new values that are written as typed scalars.
+	Canonical map[string]string
+}
+
+// Change describes who made a write and why.
+type Change struct {
+	// UserID is the user_id claim of the caller, or SYSTEM
+	UserID string
+	// Message summarises the change, e.g. for a commit message
+	Message string
+	// RequestID is the ID of the request that made the change, if any
+	RequestID string
+}
+
+// Source is a storage backend for config documents. Documents are addressed
+// by an ID that is only meaningful to the source, e.g. a file path.
+type Source interface {
+	// Name describes the source in logs and audit events
+	Name() string
+	// List returns the IDs of every document in the source
+	List() ([]string, error)
+	// Read returns the document with the given ID, or an error wrapping
+	// fs.ErrNotExist when the source has no such document
+	Read(id string) (*Document, error)
+	// Write creates or replaces a document and returns its ID. A non-empty
+	// ID is returned whenever the document was stored, even if recording
+	// the change afterwards failed.
+	Write(doc *Document, change Change) (string, error)
+	// Watch calls onChange with the ID of every document that is created or
+	// modified outside of Write, and of removed documents where the source
+	// can tell. It blocks for as long as the source is watched.
+	Watch(onChange func(id string))
+}
+
+// FileInfoSource is implemented by sources that store documents as files,
+// so that the hash and modification time of those files can be reported.
+type FileInfoSource interface {
+	Source
+	// FileInfo returns the SHA-256 of the document's file and when it was
+	// last modified
+	FileInfo(id string) (hash string, modTime time.Time, err error)
+}
+
+// VersionedSource is implemented by sources that keep the history of their
+// documents, so that configs can be read as of an earlier revision.
+type VersionedSource interface {
+	Source
+	// Revision identifies the state documents are currently read from
+	Revision() string
+	// ResolveRevision turns a user supplied reference into a revision
+	ResolveRevision(ref string) (string, error)
+	// ListAt and ReadAt behave like List and Read at the given revision
+	ListAt(revision string) ([]string, error)
+	ReadAt(revision string, id string) (*Document, error)
+}
+
+type Config struct {
+	Configs map[string]string     `yaml:"configs"`
+	Flags   map[string]flags.Flag `yaml:"flags,omitempty"`
+}
+
+// parseDocument decodes the YAML representation of a document.
+func parseDocument(product string, env string, bytes []byte) (*Document, error) {
+	var config Config
+	var typed typedConfig
+	if err := yaml.Unmarshal(bytes, &config); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(bytes, &typed); err != nil {
+		return nil, err
+	}
+	if config.Configs == nil {
+		config.Configs = make(map[string]string)
+	}
+
+	return &Document{
+		Product:     product,
+		Environment: env,
+		Configs:     config.Configs,
+		Flags:       config.Flags,
+		Canonical:   canonicalConfigs(config.Configs, typed.Configs),
+	}, nil
+}
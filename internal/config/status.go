@@ -0,0 +1,158 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status describes the loader and watcher of the active source.
+type Status struct {
+	// Loaded is set once LoadConfigs has read the source
+	Loaded bool `json:"loaded"`
+	// Watching is set while WatchConfigs runs
+	Watching bool `json:"watching"`
+	// Error is the last error that kept the source from being read, cleared
+	// by the next successful read
+	Error     string     `json:"error,omitempty"`
+	ErrorTime *time.Time `json:"error_time,omitempty"`
+}
+
+// DocumentStatus describes the last attempt to load one document of the
+// active source.
+type DocumentStatus struct {
+	ID          string `json:"id"`
+	Product     string `json:"product,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	// Hash is the SHA-256 of the document's file as last read, or of its
+	// YAML encoding for sources that do not store files
+	Hash string `json:"hash,omitempty"`
+	// ModTime is when the document's file was last modified, for sources
+	// that store files
+	ModTime  *time.Time `json:"mtime,omitempty"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+	// Error is the last error reading the document or one of its flags,
+	// cleared by the next clean read
+	Error     string     `json:"error,omitempty"`
+	ErrorTime *time.Time `json:"error_time,omitempty"`
+}
+
+var status Status
+var documents = make(map[string]DocumentStatus)
+var statusMux sync.Mutex
+
+// GetStatus returns the state of the loader and watcher.
+func GetStatus() Status {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	return status
+}
+
+func setStatus(update func(s *Status)) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	update(&status)
+}
+
+// sourceFailed records that the source could not be read, e.g. because the
+// config directory or git branch went missing.
+func sourceFailed(err error) {
+	setStatus(func(s *Status) {
+		now := time.Now()
+		s.Error = err.Error()
+		s.ErrorTime = &now
+	})
+}
+
+// sourceRead clears the error recorded by sourceFailed.
+func sourceRead() {
+	setStatus(func(s *Status) {
+		s.Error = ""
+		s.ErrorTime = nil
+	})
+}
+
+// Documents returns the state of every document the loader has attempted,
+// ordered by ID.
+func Documents() []DocumentStatus {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	all := make([]DocumentStatus, 0, len(documents))
+	for _, doc := range documents {
+		all = append(all, doc)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// documentRead records an attempt to read the document id of source. doc
+// is nil when the read failed with err; a read document may still carry
+// the err of an invalid flag.
+func documentRead(source Source, id string, doc *Document, err error) {
+	now := time.Now()
+	state := DocumentStatus{ID: id}
+
+	if files, ok := source.(FileInfoSource); ok {
+		if hash, modTime, err := files.FileInfo(id); err == nil {
+			state.Hash = hash
+			state.ModTime = &modTime
+		}
+	} else if doc != nil {
+		if bytes, err := marshalDocument(doc, nil); err == nil {
+			sum := sha256.Sum256(bytes)
+			state.Hash = hex.EncodeToString(sum[:])
+		}
+	}
+
+	statusMux.Lock()
+	defer statusMux.Unlock()
+
+	previous := documents[id]
+	state.Product, state.Environment, state.LoadedAt = previous.Product, previous.Environment, previous.LoadedAt
+	if doc != nil {
+		state.Product, state.Environment, state.LoadedAt = doc.Product, doc.Environment, &now
+	}
+	if err != nil {
+		state.Error = err.Error()
+		state.ErrorTime = &now
+	}
+	documents[id] = state
+}
+
+// loadedEnvironment returns the product environment the document id was
+// last read as, if it was ever read successfully.
+func loadedEnvironment(id string) (string, string, bool) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	state, ok := documents[id]
+	if !ok || state.LoadedAt == nil {
+		return "", "", false
+	}
+	return state.Product, state.Environment, true
+}
+
+// forgetDocument drops the state of a document removed from the source.
+func forgetDocument(id string) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	delete(documents, id)
+}
+
+// forgetDocuments drops the state of documents that are not in ids, i.e.
+// that were removed from the source.
+func forgetDocuments(ids []string) {
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = true
+	}
+
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	for id := range documents {
+		if !listed[id] {
+			delete(documents, id)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"simpleConfigServer/internal/flags"
+	"sort"
+)
+
+// Store is a read-only view of every loaded document. Handlers read configs
+// through a Store instead of reaching into the loader's state.
+type Store interface {
+	// Products lists the loaded products in name order
+	Products() []string
+	// Environments lists the loaded environments of a product in name order
+	Environments(product string) []string
+	// HasProduct reports whether any environment of product is loaded
+	HasProduct(product string) bool
+	// Configs returns the values of a product environment with placeholders
+	// resolved
+	Configs(product string, env string) (map[string]string, bool)
+	// RawConfigs returns the values of a product environment as written
+	RawConfigs(product string, env string) (map[string]string, bool)
+	// CanonicalConfigs returns the single spelling of the values of a
+	// product environment that are typed scalars, e.g. "true" for yes
+	CanonicalConfigs(product string, env string) map[string]string
+	// Flags returns the feature flags of a product environment
+	Flags(product string, env string) (map[string]flags.Flag, bool)
+}
+
+// snapshot is an immutable Store. The loader builds a new snapshot for every
+// change and swaps it in, so readers never need to hold a lock.
+type snapshot struct {
+	raw       map[string]map[string]map[string]string
+	resolved  map[string]map[string]map[string]string
+	canonical map[string]map[string]map[string]string
+	flags     map[string]map[string]map[string]flags.Flag
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		raw:       make(map[string]map[string]map[string]string),
+		resolved:  make(map[string]map[string]map[string]string),
+		canonical: make(map[string]map[string]map[string]string),
+		flags:     make(map[string]map[string]map[string]flags.Flag),
+	}
+}
+
+func (s *snapshot) Products() []string {
+	products := make([]string, 0, len(s.raw))
+	for product := range s.raw {
+		products = append(products, product)
+	}
+	sort.Strings(products)
+	return products
+}
+
+func (s *snapshot) Environments(product string) []string {
+	envs := make([]string, 0, len(s.raw[product]))
+	for env := range s.raw[product] {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+func (s *snapshot) HasProduct(product string) bool {
+	_, ok := s.raw[product]
+	return ok
+}
+
+func (s *snapshot) Configs(product string, env string) (map[string]string, bool) {
+	configs, ok := s.resolved[product][env]
+	return configs, ok
+}
+
+func (s *snapshot) RawConfigs(product string, env string) (map[string]string, bool) {
+	configs, ok := s.raw[product][env]
+	return configs, ok
+}
+
+func (s *snapshot) CanonicalConfigs(product string, env string) map[string]string {
+	return s.canonical[product][env]
+}
+
+func (s *snapshot) Flags(product string, env string) (map[string]flags.Flag, bool) {
+	envFlags, ok := s.flags[product][env]
+	return envFlags, ok
+}
@@ -3,45 +3,60 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"simpleConfigServer/internal/audit"
 	"simpleConfigServer/internal/logger"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-func WatchConfigDir(configDir string) {
-	watcher, err := fsnotify.NewWatcher()
+const (
+	WatchModeAuto     = "auto"
+	WatchModeFsnotify = "fsnotify"
+	WatchModePoll     = "poll"
+)
+
+// WatchConfigDir calls onChange for every file in configDir that is created,
+// modified, removed or renamed. In auto mode it uses fsnotify and falls back to polling when
+// inotify registration fails.
+func WatchConfigDir(configDir string, mode string, pollInterval time.Duration, onChange func(path string)) {
+	if mode == WatchModePoll {
+		PollConfigDir(configDir, pollInterval, onChange)
+		return
+	}
+
+	watcher, err := newDirWatcher(configDir)
 	if err != nil {
-		logger.Log.Fatal(err)
+		if mode == WatchModeFsnotify {
+			logger.Log.Fatal(err)
+		}
+		logger.Log.Printf("Falling back to polling config directory %s: %v", configDir, err)
+		audit.LogSystem("CONFIG_WATCH", "FALLBACK", map[string]interface{}{
+			"dir":   configDir,
+			"error": err.Error(),
+		})
+		PollConfigDir(configDir, pollInterval, onChange)
+		return
 	}
 	defer watcher.Close()
 
 	logger.Log.Printf("Watching config directory: %s", configDir)
 
-	err = filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			err = watcher.Add(path)
-			if err != nil {
-				logger.Log.Printf("Error adding watcher to directory %s: %v", path, err)
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		logger.Log.Fatal(err)
-	}
-
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
+			switch {
+			case event.Op&(fsnotify.Write|fsnotify.Create) != 0:
 				logger.Log.Printf("Config file changed: %s", event.Name)
-				LoadConfigFile(event.Name)
+				onChange(event.Name)
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				// A renamed file is reported again by the Create of its
+				// new name
+				logger.Log.Printf("Config file removed: %s", event.Name)
+				onChange(event.Name)
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -51,3 +66,32 @@ func WatchConfigDir(configDir string) {
 		}
 	}
 }
+
+func newDirWatcher(configDir string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				logger.Log.Printf("Error adding watcher to directory %s: %v", path, err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	return watcher, nil
+}
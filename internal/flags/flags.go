@@ -0,0 +1,234 @@
+package flags
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Flag is a feature flag as declared under the flags section of a config
+// file. Rules are evaluated in order and the first one that applies wins;
+// when none applies the flag resolves to Default.
+type Flag struct {
+	Default interface{} `yaml:"default" json:"default"`
+	Rules   []Rule      `yaml:"rules" json:"rules,omitempty"`
+}
+
+// Rule targets requests whose attributes satisfy every entry of Match.
+// Attributes are named "header.<Header-Name>" or "claim.<jwt_claim>".
+// Expected values are either a string, a list of strings (any of them) or a
+// version comparison such as ">=2.3.0". A rule with a Percentage only
+// applies to that share of client keys.
+type Rule struct {
+	Name       string                 `yaml:"name" json:"name"`
+	Match      map[string]interface{} `yaml:"match" json:"match,omitempty"`
+	Percentage *float64               `yaml:"percentage" json:"percentage,omitempty"`
+	Value      interface{}            `yaml:"value" json:"value"`
+}
+
+// Context carries what a flag is evaluated against.
+type Context struct {
+	// Key identifies the client for percentage rollouts, so that the same
+	// client consistently gets the same result.
+	Key string
+	// Attribute looks up a "header.*" or "claim.*" attribute of the request.
+	Attribute func(name string) (string, bool)
+}
+
+const (
+	ReasonRuleMatch = "RULE_MATCH"
+	ReasonRollout   = "ROLLOUT"
+	ReasonDefault   = "DEFAULT"
+)
+
+// Result is the outcome of evaluating a flag.
+type Result struct {
+	Flag   string      `json:"flag"`
+	Value  interface{} `json:"value"`
+	Rule   string      `json:"rule,omitempty"`
+	Reason string      `json:"reason"`
+}
+
+// Validate reports rules that can never be evaluated.
+func (f Flag) Validate() error {
+	if !isScalar(f.Default) {
+		return fmt.Errorf("default must be a scalar value")
+	}
+	for i, rule := range f.Rules {
+		name := rule.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		if rule.Percentage != nil && (*rule.Percentage < 0 || *rule.Percentage > 100) {
+			return fmt.Errorf("rule %s: percentage must be between 0 and 100", name)
+		}
+		if !isScalar(rule.Value) {
+			return fmt.Errorf("rule %s: value must be a scalar value", name)
+		}
+		for attribute, expected := range rule.Match {
+			if !strings.HasPrefix(attribute, "header.") && !strings.HasPrefix(attribute, "claim.") {
+				return fmt.Errorf("rule %s: unknown attribute %q", name, attribute)
+			}
+			if _, ok := expectedValues(expected); !ok {
+				return fmt.Errorf("rule %s: %s must be a string or a list of strings", name, attribute)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate resolves the flag for the given context.
+func (f Flag) Evaluate(name string, ctx Context) Result {
+	for i, rule := range f.Rules {
+		if !rule.matches(ctx) {
+			continue
+		}
+		ruleName := rule.Name
+		if ruleName == "" {
+			ruleName = "#" + strconv.Itoa(i+1)
+		}
+		if rule.Percentage == nil {
+			return Result{Flag: name, Value: rule.Value, Rule: ruleName, Reason: ReasonRuleMatch}
+		}
+		if bucket(name, ctx.Key) < *rule.Percentage {
+			return Result{Flag: name, Value: rule.Value, Rule: ruleName, Reason: ReasonRollout}
+		}
+	}
+	return Result{Flag: name, Value: f.Default, Reason: ReasonDefault}
+}
+
+func (r Rule) matches(ctx Context) bool {
+	for attribute, expected := range r.Match {
+		actual, ok := "", false
+		if ctx.Attribute != nil {
+			actual, ok = ctx.Attribute(attribute)
+		}
+		if !ok {
+			return false
+		}
+		values, _ := expectedValues(expected)
+		matched := false
+		for _, value := range values {
+			if matchValue(actual, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// bucket maps a client key to a stable position in [0, 100) for the flag.
+func bucket(flag string, key string) float64 {
+	sum := sha256.Sum256([]byte(flag + ":" + key))
+	return float64(binary.BigEndian.Uint32(sum[:4])%10000) / 100
+}
+
+func expectedValues(expected interface{}) ([]string, bool) {
+	switch v := expected.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if !isScalar(item) || item == nil {
+				return nil, false
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, true
+	default:
+		if !isScalar(v) || v == nil {
+			return nil, false
+		}
+		return []string{fmt.Sprint(v)}, true
+	}
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case nil, bool, string, int, int64, uint64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+var versionOperators = []string{">=", "<=", "!=", ">", "<"}
+
+// matchValue compares an attribute with an expected value. Expected values
+// starting with a comparison operator compare dotted version numbers, except
+// that != compares plain strings unless both sides are versions.
+func matchValue(actual string, expected string) bool {
+	for _, op := range versionOperators {
+		if !strings.HasPrefix(expected, op) {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(expected, op))
+		if op == "!=" && (!isVersion(actual) || !isVersion(value)) {
+			return actual != value
+		}
+		cmp := compareVersions(actual, value)
+		switch op {
+		case ">=":
+			return cmp >= 0
+		case "<=":
+			return cmp <= 0
+		case "!=":
+			return cmp != 0
+		case ">":
+			return cmp > 0
+		case "<":
+			return cmp < 0
+		}
+	}
+	return actual == expected
+}
+
+// compareVersions compares dotted numeric versions such as "2.10.1" and
+// "v2.9". Missing components count as zero and non-numeric suffixes are
+// ignored.
+func compareVersions(a string, b string) int {
+	pa := versionParts(a)
+	pb := versionParts(b)
+	for len(pa) < len(pb) {
+		pa = append(pa, 0)
+	}
+	for len(pb) < len(pa) {
+		pb = append(pb, 0)
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionPattern matches the versions compareVersions understands, e.g.
+// "2.10.1", "v2.9" or "3.0.0-beta.1".
+var versionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*([-+].*)?$`)
+
+func isVersion(value string) bool {
+	return versionPattern.MatchString(strings.TrimSpace(value))
+}
+
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(field)
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,196 @@
+package flags
+
+import (
+	"strconv"
+	"testing"
+)
+
+func percentage(p float64) *float64 {
+	return &p
+}
+
+func attributes(values map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func TestBucket(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		key := "client-" + strconv.Itoa(i)
+		b := bucket("checkout", key)
+		if b < 0 || b >= 100 {
+			t.Fatalf("bucket(checkout, %s) = %v, want [0, 100)", key, b)
+		}
+		if again := bucket("checkout", key); again != b {
+			t.Fatalf("bucket(checkout, %s) = %v then %v, want a stable bucket", key, b, again)
+		}
+	}
+}
+
+func TestEvaluatePercentage(t *testing.T) {
+	const clients = 10000
+
+	tests := []struct {
+		name       string
+		percentage float64
+		min, max   int
+	}{
+		{name: "none", percentage: 0, min: 0, max: 0},
+		{name: "all", percentage: 100, min: clients, max: clients},
+		{name: "a tenth", percentage: 10, min: 900, max: 1100},
+		{name: "half", percentage: 50, min: 4800, max: 5200},
+		{name: "fraction of a percent", percentage: 0.5, min: 20, max: 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := Flag{Default: false, Rules: []Rule{{Name: "rollout", Percentage: percentage(tt.percentage), Value: true}}}
+			enabled := 0
+			for i := 0; i < clients; i++ {
+				result := flag.Evaluate("checkout", Context{Key: "client-" + strconv.Itoa(i)})
+				switch result.Reason {
+				case ReasonRollout:
+					enabled++
+					if result.Value != true || result.Rule != "rollout" {
+						t.Fatalf("rollout result %+v", result)
+					}
+				case ReasonDefault:
+					if result.Value != false {
+						t.Fatalf("default result %+v", result)
+					}
+				default:
+					t.Fatalf("unexpected reason %s", result.Reason)
+				}
+			}
+			if enabled < tt.min || enabled > tt.max {
+				t.Errorf("%v%% enabled the flag for %d of %d clients, want %d to %d", tt.percentage, enabled, clients, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestEvaluatePercentageIsStable(t *testing.T) {
+	small := Flag{Default: false, Rules: []Rule{{Percentage: percentage(20), Value: true}}}
+	large := Flag{Default: false, Rules: []Rule{{Percentage: percentage(60), Value: true}}}
+
+	differs := false
+	for i := 0; i < 1000; i++ {
+		ctx := Context{Key: "client-" + strconv.Itoa(i)}
+		// Raising the percentage only ever adds clients
+		if small.Evaluate("checkout", ctx).Value == true && large.Evaluate("checkout", ctx).Value != true {
+			t.Fatalf("client-%d lost the flag when the rollout grew", i)
+		}
+		// Flags are bucketed independently
+		if bucket("checkout", ctx.Key) != bucket("search", ctx.Key) {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("checkout and search put every client in the same bucket")
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	flag := Flag{
+		Default: "off",
+		Rules: []Rule{
+			{Name: "beta", Match: map[string]interface{}{"claim.group": []interface{}{"beta", "staff"}}, Value: "beta"},
+			{Name: "new-app", Match: map[string]interface{}{"header.X-App-Version": ">=2.3.0", "claim.country": "NL"}, Value: "on"},
+			{Match: map[string]interface{}{"header.X-App-Version": "<2"}, Value: "legacy"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		value      interface{}
+		rule       string
+		reason     string
+	}{
+		{name: "no attributes", attributes: nil, value: "off", reason: ReasonDefault},
+		{name: "any of a list", attributes: map[string]string{"claim.group": "staff"}, value: "beta", rule: "beta", reason: ReasonRuleMatch},
+		{name: "first matching rule wins", attributes: map[string]string{"claim.group": "beta", "header.X-App-Version": "1.0"}, value: "beta", rule: "beta", reason: ReasonRuleMatch},
+		{name: "every entry must match", attributes: map[string]string{"header.X-App-Version": "2.3.0"}, value: "off", reason: ReasonDefault},
+		{name: "version and claim", attributes: map[string]string{"header.X-App-Version": "2.10", "claim.country": "NL"}, value: "on", rule: "new-app", reason: ReasonRuleMatch},
+		{name: "version too old", attributes: map[string]string{"header.X-App-Version": "2.2.9", "claim.country": "NL"}, value: "off", reason: ReasonDefault},
+		{name: "unnamed rule", attributes: map[string]string{"header.X-App-Version": "v1.9.9"}, value: "legacy", rule: "#3", reason: ReasonRuleMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := flag.Evaluate("ui", Context{Key: "client", Attribute: attributes(tt.attributes)})
+			if result.Value != tt.value || result.Rule != tt.rule || result.Reason != tt.reason {
+				t.Errorf("got %+v, want value %v, rule %q, reason %s", result, tt.value, tt.rule, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		actual   string
+		expected string
+		want     bool
+	}{
+		{"2.3.0", ">=2.3.0", true},
+		{"2.3", ">=2.3.0", true},
+		{"2.10.0", ">=2.9", true},
+		{"2.2.9", ">=2.3.0", false},
+		{"v3.0.0", ">2.99", true},
+		{"3.0.0-beta.1", "<=3.0.0", true},
+		{"3.0.1", "<=3.0.0", false},
+		{"1.9", "<2", true},
+		{"2.0.0+build.5", "<2", false},
+		{"2.0", "!=2.0.0", false},
+		{"2.0.1", "!= 2.0.0", true},
+		{"v2.0.0", "!=2", false},
+		{"beta", "!=beta", false},
+		{"beta", "!=alpha", true},
+		{"beta", "!= alpha", true},
+		{"eu-west", "!=us-east", true},
+		{"", "!=0", true},
+		{"0", "!=", true},
+		{"2.0", "!=beta", true},
+		{"2.0", "2.0", true},
+		{"2.0", "2.0.0", false},
+		{"beta", "beta", true},
+		{"Beta", "beta", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchValue(tt.actual, tt.expected); got != tt.want {
+			t.Errorf("matchValue(%q, %q) = %v, want %v", tt.actual, tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    Flag
+		wantErr string
+	}{
+		{name: "valid", flag: Flag{Default: false, Rules: []Rule{{Match: map[string]interface{}{"claim.group": []interface{}{"a", 1}}, Percentage: percentage(100), Value: true}}}},
+		{name: "percentage below zero", flag: Flag{Rules: []Rule{{Name: "r", Percentage: percentage(-1)}}}, wantErr: "rule r: percentage must be between 0 and 100"},
+		{name: "percentage above 100", flag: Flag{Rules: []Rule{{Percentage: percentage(100.5)}}}, wantErr: "rule #1: percentage must be between 0 and 100"},
+		{name: "unknown attribute", flag: Flag{Rules: []Rule{{Match: map[string]interface{}{"query.x": "1"}}}}, wantErr: `rule #1: unknown attribute "query.x"`},
+		{name: "nested expected value", flag: Flag{Rules: []Rule{{Match: map[string]interface{}{"claim.x": []interface{}{[]interface{}{"a"}}}}}}, wantErr: "rule #1: claim.x must be a string or a list of strings"},
+		{name: "null expected value", flag: Flag{Rules: []Rule{{Match: map[string]interface{}{"claim.x": nil}}}}, wantErr: "rule #1: claim.x must be a string or a list of strings"},
+		{name: "non-scalar default", flag: Flag{Default: []interface{}{"a"}}, wantErr: "default must be a scalar value"},
+		{name: "non-scalar value", flag: Flag{Rules: []Rule{{Value: map[interface{}]interface{}{"a": 1}}}}, wantErr: "rule #1: value must be a scalar value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.flag.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("Validate() = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ErrNameCollision is returned by encoders that rename keys when two keys
+// would be written under the same name, e.g. a-b and a_b as A_B.
+var ErrNameCollision = errors.New("keys collide")
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Encode(values map[string]string) ([]byte, error) {
+	return json.Marshal(values)
+}
+
+type yamlEncoder struct{}
+
+func (yamlEncoder) ContentType() string { return "application/yaml" }
+
+func (yamlEncoder) Encode(values map[string]string) ([]byte, error) {
+	return yaml.Marshal(values)
+}
+
+// envEncoder writes dotenv files: KEY="value" with upper-cased keys.
+type envEncoder struct{}
+
+func (envEncoder) ContentType() string { return "text/x-dotenv; charset=utf-8" }
+
+func (envEncoder) Encode(values map[string]string) ([]byte, error) {
+	if err := checkEnvNames(values); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, key := range sortedKeys(values) {
+		b.WriteString(envName(key))
+		b.WriteString("=")
+		b.WriteString(doubleQuote(values[key]))
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// propertiesEncoder writes Java .properties files, escaped as described by
+// java.util.Properties#store.
+type propertiesEncoder struct{}
+
+func (propertiesEncoder) ContentType() string { return "text/x-java-properties; charset=utf-8" }
+
+func (propertiesEncoder) Encode(values map[string]string) ([]byte, error) {
+	var b strings.Builder
+	for _, key := range sortedKeys(values) {
+		b.WriteString(escapeProperty(key, true))
+		b.WriteString("=")
+		b.WriteString(escapeProperty(values[key], false))
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// shellEncoder writes POSIX shell export lines suitable for eval or source.
+type shellEncoder struct{}
+
+func (shellEncoder) ContentType() string { return "text/x-shellscript; charset=utf-8" }
+
+func (shellEncoder) Encode(values map[string]string) ([]byte, error) {
+	if err := checkEnvNames(values); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, key := range sortedKeys(values) {
+		b.WriteString("export ")
+		b.WriteString(envName(key))
+		b.WriteString("=")
+		b.WriteString(singleQuote(values[key]))
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// rawEncoder writes the bare value followed by a newline, so that shell
+// scripts can use $(curl ...) without parsing. Typed values are written in
+// their canonical spelling, since there is no YAML left to tell yes apart
+// from a string.
+type rawEncoder struct{}
+
+func (rawEncoder) ContentType() string { return "text/plain; charset=utf-8" }
+
+func (rawEncoder) SingleValue() {}
+
+func (rawEncoder) Canonical() {}
+
+func (rawEncoder) Encode(values map[string]string) ([]byte, error) {
+	if len(values) != 1 {
+		return nil, errors.New("raw output holds exactly one value")
+	}
+	for _, value := range values {
+		return []byte(value + "\n"), nil
+	}
+	return nil, nil
+}
+
+// envName turns a config key into a valid environment variable name.
+func envName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// checkEnvNames reports keys of values that envName maps to the same name,
+// since only one of them could be written.
+func checkEnvNames(values map[string]string) error {
+	names := make(map[string]string, len(values))
+	for _, key := range sortedKeys(values) {
+		name := envName(key)
+		if other, exists := names[name]; exists {
+			return fmt.Errorf("%w: %s and %s are both written as %s", ErrNameCollision, other, key, name)
+		}
+		names[name] = key
+	}
+	return nil
+}
+
+func doubleQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func singleQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func escapeProperty(value string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteString(`\ `)
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			if r > unicode.MaxASCII {
+				// Non-ASCII characters are written as UTF-16 \uXXXX escapes.
+				for _, unit := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&b, `\u%04X`, unit)
+				}
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package format
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder renders a set of config values in one output format.
+type Encoder interface {
+	ContentType() string
+	Encode(values map[string]string) ([]byte, error)
+}
+
+// SingleValueEncoder is implemented by encoders that can only render the
+// value of one key.
+type SingleValueEncoder interface {
+	Encoder
+	SingleValue()
+}
+
+// CanonicalEncoder is implemented by encoders that render booleans, nulls
+// and hexadecimal integers in their canonical spelling rather than as
+// written, e.g. "true" for yes.
+type CanonicalEncoder interface {
+	Encoder
+	Canonical()
+}
+
+type registration struct {
+	name       string
+	mediaTypes []string
+	encoder    Encoder
+}
+
+var (
+	registry []registration
+	mu       sync.RWMutex
+)
+
+const DefaultFormat = "json"
+
+func init() {
+	Register("json", []string{"application/json"}, jsonEncoder{})
+	Register("yaml", []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}, yamlEncoder{})
+	Register("env", []string{"text/x-dotenv", "application/x-dotenv"}, envEncoder{})
+	Register("properties", []string{"text/x-java-properties", "text/x-properties"}, propertiesEncoder{})
+	Register("shell", []string{"text/x-shellscript", "application/x-sh"}, shellEncoder{})
+	Register("raw", []string{"text/plain"}, rawEncoder{})
+}
+
+// Register makes an encoder selectable by name through ?format= and by any
+// of the given media types through the Accept header.
+func Register(name string, mediaTypes []string, encoder Encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append(registry, registration{name: name, mediaTypes: mediaTypes, encoder: encoder})
+}
+
+// Names lists the registered format names.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for _, r := range registry {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+// Lookup returns the encoder registered under name.
+func Lookup(name string) (Encoder, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, r := range registry {
+		if r.name == name {
+			return r.encoder, true
+		}
+	}
+	return nil, false
+}
+
+// Negotiate picks an encoder from an explicit format name, falling back to
+// the Accept header and then to JSON. It reports false when the client asked
+// for something no encoder can produce.
+func Negotiate(name string, accept string) (Encoder, bool) {
+	if name != "" {
+		return Lookup(name)
+	}
+	if strings.TrimSpace(accept) == "" {
+		return Lookup(DefaultFormat)
+	}
+
+	for _, mediaType := range parseAccept(accept) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return Lookup(DefaultFormat)
+		}
+		if encoder, ok := lookupMediaType(mediaType); ok {
+			return encoder, true
+		}
+	}
+	return nil, false
+}
+
+func lookupMediaType(mediaType string) (Encoder, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, r := range registry {
+		for _, t := range r.mediaTypes {
+			if t == mediaType {
+				return r.encoder, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// parseAccept returns the media types of an Accept header ordered by
+// preference. Types with q=0 are dropped.
+func parseAccept(accept string) []string {
+	type weighted struct {
+		mediaType string
+		q         float64
+	}
+
+	var types []weighted
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.TrimSpace(name) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			types = append(types, weighted{mediaType, q})
+		}
+	}
+
+	sort.SliceStable(types, func(i, j int) bool { return types[i].q > types[j].q })
+
+	result := make([]string, len(types))
+	for i, t := range types {
+		result[i] = t.mediaType
+	}
+	return result
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,5 @@
+// Package configpb holds the protobuf messages and gRPC stubs generated from
+// config.proto.
+package configpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative config.proto
@@ -0,0 +1,363 @@
+// Package grpcserver serves configs over gRPC, see configpb/config.proto.
+// Calls go through the same tenant lookup, IP filter, rate limiter, token
+// validation and audit log as the HTTP API.
+package grpcserver
+
+import (
+	"context"
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/auth"
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/grpcserver/configpb"
+	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/requestid"
+	"simpleConfigServer/internal/tenant"
+	"simpleConfigServer/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the ErrorInfo attached to failed calls. Its
+// reason is the code the HTTP API reports for the same error.
+const errorDomain = "simple-config-server"
+
+type server struct {
+	configpb.UnimplementedConfigServiceServer
+}
+
+// New returns a gRPC server exposing ConfigService.
+func New() *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(traceUnary), grpc.StreamInterceptor(traceStream))
+	configpb.RegisterConfigServiceServer(s, &server{})
+	return s
+}
+
+// Serve serves ConfigService on addr, e.g. ":9090". It blocks until the
+// listener fails.
+func Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logger.Log.Printf("Starting gRPC server on %s", addr)
+	return New().Serve(listener)
+}
+
+// metadataCarrier reads and writes W3C trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if values := metadata.MD(m).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key string, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// withRequestID gives a call an ID, the one in its "x-request-id" metadata
+// when valid, and returns it to the caller in the response header.
+func withRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := requestid.Accept(metadataCarrier(md).Get(requestid.Header))
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	return requestid.NewContext(ctx, id)
+}
+
+// startSpan starts the span of a call, continuing the trace of the caller.
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	return tracing.StartServer(ctx, method,
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("request.id", requestid.FromContext(ctx)))
+}
+
+// endSpan ends the span of a call that returned err.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("rpc.grpc.status_code", status.Code(err).String()))
+		tracing.Fail(span, err)
+	}
+	span.End()
+}
+
+func traceUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, span := startSpan(withRequestID(ctx), info.FullMethod)
+	resp, err := handler(ctx, req)
+	endSpan(span, err)
+	return resp, err
+}
+
+func traceStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startSpan(withRequestID(stream.Context()), info.FullMethod)
+	err := handler(srv, &tracedStream{ServerStream: stream, ctx: ctx})
+	endSpan(span, err)
+	return err
+}
+
+// tracedStream is a stream whose context holds the span of the call.
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+// request is a call that passed authorize, along with the tenant it
+// addresses and the audit stream of the tenant, stamped with the request ID.
+type request struct {
+	ip     string
+	claims *auth.Claims
+	tenant *tenant.Tenant
+	audit  *audit.Stream
+}
+
+// fail returns a status error carrying code as the reason of its ErrorInfo.
+func fail(c codes.Code, code string, message string) error {
+	st := status.New(c, message)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: errorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// authorize resolves the tenant of a call and runs tenant.Authorize with the
+// token of the "authorization" metadata.
+func authorize(ctx context.Context, tenantName string, names map[string]string) (*request, error) {
+	ip := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	method, _ := grpc.Method(ctx)
+
+	t := tenant.Default()
+	if tenant.Enabled() {
+		var found bool
+		t, found = tenant.Get(tenantName)
+		if !found {
+			audit.Default().WithContext(ctx).LogSecurity(ip, "DENIED", "TENANT", map[string]interface{}{
+				"reason": "Unknown tenant",
+				"tenant": tenantName,
+				"path":   method,
+			})
+			return nil, fail(codes.NotFound, "tenant_not_found", "Tenant not found")
+		}
+	}
+
+	stream := t.Audit.WithContext(ctx)
+	stream.LogSystem("REQUEST", "START", map[string]interface{}{
+		"path": method,
+		"ip":   ip,
+	})
+
+	// Names are checked as in the HTTP API, but keys are never patterns here
+	for name, value := range names {
+		valid := config.NamePattern.MatchString(value)
+		if name == "key" {
+			valid = config.IsKeyName(value)
+		}
+		if !valid {
+			stream.LogSystem("REQUEST", "INVALID", map[string]interface{}{
+				"reason": "Invalid " + name + " name",
+				"path":   method,
+			})
+			return nil, fail(codes.InvalidArgument, "invalid_name", "Invalid "+name+" name "+value)
+		}
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+
+	claims, err := t.Authorize(ctx, ip, token)
+	switch err {
+	case nil:
+	case tenant.ErrIPNotAllowed:
+		return nil, fail(codes.PermissionDenied, "ip_not_allowed", "IP not allowed")
+	case tenant.ErrRateLimited:
+		return nil, fail(codes.ResourceExhausted, "rate_limited", "Rate limit exceeded")
+	case auth.ErrTokenMissing:
+		return nil, fail(codes.Unauthenticated, "token_missing", "Unauthorized: "+err.Error())
+	case auth.ErrTokenExpired:
+		return nil, fail(codes.Unauthenticated, "token_expired", "Unauthorized: "+err.Error())
+	default:
+		return nil, fail(codes.Unauthenticated, "token_invalid", "Unauthorized: "+err.Error())
+	}
+
+	return &request{ip: ip, claims: claims, tenant: t, audit: stream}, nil
+}
+
+// configs returns the values of a product environment the caller may read.
+// Denials are audited as access to key.
+func (r *request) configs(product string, env string, key string, raw bool) (map[string]string, error) {
+	deny := func(c codes.Code, code string, message string) error {
+		r.audit.LogConfigAccess(r.ip, "DENIED", product, env, key, r.claims.UserID)
+		return fail(c, code, message)
+	}
+
+	if !config.IsSupportedEnv(env) {
+		return nil, deny(codes.NotFound, "env_unsupported", "Environment not supported")
+	}
+	if !r.claims.AllowsProduct(product) {
+		return nil, deny(codes.PermissionDenied, "product_forbidden", "Product not allowed")
+	}
+
+	store := config.GetStore()
+	if !store.HasProduct(r.tenant.Product(product)) {
+		return nil, deny(codes.NotFound, "product_not_found", "Product not found")
+	}
+
+	envConfigs, found := store.Configs(r.tenant.Product(product), env)
+	if raw {
+		envConfigs, found = store.RawConfigs(r.tenant.Product(product), env)
+	}
+	if !found {
+		return nil, deny(codes.NotFound, "env_not_found", "No configs for "+product+"/"+env)
+	}
+	return envConfigs, nil
+}
+
+func (s *server) Get(ctx context.Context, in *configpb.GetRequest) (*configpb.GetResponse, error) {
+	r, err := authorize(ctx, in.Tenant, map[string]string{"product": in.Product, "env": in.Env, "key": in.Key})
+	if err != nil {
+		return nil, err
+	}
+
+	envConfigs, err := r.configs(in.Product, in.Env, in.Key, in.Raw)
+	if err != nil {
+		return nil, err
+	}
+	value, found := envConfigs[in.Key]
+	if !found {
+		r.audit.LogConfigAccess(r.ip, "DENIED", in.Product, in.Env, in.Key, r.claims.UserID)
+		return nil, fail(codes.NotFound, "key_not_found", "Key "+in.Key+" not found in "+in.Product+"/"+in.Env)
+	}
+
+	r.audit.LogConfigAccess(r.ip, "SUCCESS", in.Product, in.Env, in.Key, r.claims.UserID)
+	return &configpb.GetResponse{Key: in.Key, Value: value}, nil
+}
+
+func (s *server) GetAll(ctx context.Context, in *configpb.GetAllRequest) (*configpb.GetAllResponse, error) {
+	r, err := authorize(ctx, in.Tenant, map[string]string{"product": in.Product, "env": in.Env})
+	if err != nil {
+		return nil, err
+	}
+
+	envConfigs, err := r.configs(in.Product, in.Env, "*", in.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	r.audit.LogConfigAccess(r.ip, "SUCCESS", in.Product, in.Env, "*", r.claims.UserID)
+	return &configpb.GetAllResponse{Configs: envConfigs}, nil
+}
+
+func (s *server) List(ctx context.Context, in *configpb.ListRequest) (*configpb.ListResponse, error) {
+	names := map[string]string{}
+	if in.Product != "" {
+		names["product"] = in.Product
+	}
+	r, err := authorize(ctx, in.Tenant, names)
+	if err != nil {
+		return nil, err
+	}
+	store := config.GetStore()
+
+	if in.Product == "" {
+		var products []string
+		for _, stored := range store.Products() {
+			if product, ok := r.tenant.ProductName(stored); ok && r.claims.AllowsProduct(product) {
+				products = append(products, product)
+			}
+		}
+		r.audit.LogConfigList(r.ip, "SUCCESS", "*", "", r.claims.UserID)
+		return &configpb.ListResponse{Items: products}, nil
+	}
+
+	if !r.claims.AllowsProduct(in.Product) {
+		r.audit.LogConfigList(r.ip, "DENIED", in.Product, "*", r.claims.UserID)
+		return nil, fail(codes.PermissionDenied, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(in.Product)) {
+		r.audit.LogConfigList(r.ip, "DENIED", in.Product, "*", r.claims.UserID)
+		return nil, fail(codes.NotFound, "product_not_found", "Product not found")
+	}
+
+	r.audit.LogConfigList(r.ip, "SUCCESS", in.Product, "*", r.claims.UserID)
+	return &configpb.ListResponse{Items: store.Environments(r.tenant.Product(in.Product))}, nil
+}
+
+// Watch is authorized and rate limited once, when the stream opens. Every
+// message sent is audited as an access to every key. The stream ends when
+// the token expires.
+func (s *server) Watch(in *configpb.WatchRequest, stream grpc.ServerStreamingServer[configpb.WatchResponse]) error {
+	ctx := stream.Context()
+	r, err := authorize(ctx, in.Tenant, map[string]string{"product": in.Product, "env": in.Env})
+	if err != nil {
+		return err
+	}
+
+	var expired <-chan time.Time
+	if r.claims.ExpiresAt != 0 {
+		timer := time.NewTimer(time.Until(time.Unix(r.claims.ExpiresAt, 0)))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var sent map[string]string
+	for {
+		changed := config.Changed()
+		envConfigs, err := r.configs(in.Product, in.Env, "*", in.Raw)
+		if err != nil {
+			return err
+		}
+
+		if sent == nil || !maps.Equal(envConfigs, sent) {
+			r.audit.LogConfigAccess(r.ip, "SUCCESS", in.Product, in.Env, "*", r.claims.UserID)
+			if err := stream.Send(&configpb.WatchResponse{Configs: envConfigs, Revision: config.GetRevision()}); err != nil {
+				return err
+			}
+			sent = envConfigs
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+			return fail(codes.Unauthenticated, "token_expired", "Unauthorized: "+auth.ErrTokenExpired.Error())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
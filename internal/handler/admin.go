@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"strings"
+
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/ipfilter"
+	"simpleConfigServer/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// configsState is the body of /admin/configs: the loader and watcher of the
+// config source and every document they attempted.
+type configsState struct {
+	Status    config.Status           `json:"status"`
+	Documents []config.DocumentStatus `json:"documents"`
+}
+
+// allowlistState is one entry of /admin/allowlist.
+type allowlistState struct {
+	Tenant string `json:"tenant,omitempty"`
+	ipfilter.Status
+	IPs []string `json:"ips"`
+}
+
+// namedFilter is an allowlist along with the tenant it belongs to, "" for
+// the server wide one.
+type namedFilter struct {
+	tenant string
+	filter *ipfilter.Filter
+}
+
+// allowlists returns the server wide allowlist followed by those of the
+// tenants that have an allowlist file.
+func allowlists() []namedFilter {
+	filters := []namedFilter{{filter: ipfilter.Default()}}
+	for _, t := range tenant.All() {
+		if t.HasOwnAllowlist() {
+			filters = append(filters, namedFilter{tenant: t.Name, filter: t.Filter})
+		}
+	}
+	return filters
+}
+
+// RegisterAdmin adds the admin routes to router, each of which needs token
+// as a bearer token. They are neither filtered by IP nor rate limited, so
+// that a broken allowlist can be reloaded, but every call is audited.
+func RegisterAdmin(router fiber.Router, token string) {
+	router.Use(adminAuth(token))
+
+	router.Get("/configs", AdminConfigsHandler)
+	router.Post("/configs/reload", AdminReloadConfigsHandler)
+	router.Get("/allowlist", AdminAllowlistHandler)
+	router.Post("/allowlist/reload", AdminReloadAllowlistHandler)
+}
+
+// adminAuth admits requests carrying token as their bearer token.
+func adminAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stream := audit.Default().WithContext(c.UserContext())
+		if code, detail := checkBearer(c, token); code != "" {
+			stream.LogSecurity(c.IP(), "DENIED", "ADMIN", map[string]interface{}{
+				"reason": code,
+				"method": c.Method(),
+				"path":   c.Path(),
+			})
+			return problem(c, fiber.StatusUnauthorized, code, detail)
+		}
+		stream.LogSecurity(c.IP(), "ALLOWED", "ADMIN", map[string]interface{}{
+			"method": c.Method(),
+			"path":   c.Path(),
+		})
+		return c.Next()
+	}
+}
+
+// AdminConfigsHandler reports the state of the config source and of every
+// document: hash, modification time, last load and last error. GET
+// /admin/configs
+func AdminConfigsHandler(c *fiber.Ctx) error {
+	return c.JSON(configsState{Status: config.GetStatus(), Documents: config.Documents()})
+}
+
+// AdminReloadConfigsHandler reads every document of the config source again
+// and answers like AdminConfigsHandler. Documents that fail to load keep
+// their previous values. POST /admin/configs/reload
+func AdminReloadConfigsHandler(c *fiber.Ctx) error {
+	stream := audit.Default().WithContext(c.UserContext())
+	if err := config.Reload(c.UserContext()); err != nil {
+		stream.Log("ADMIN_RELOAD", c.IP(), "FAILED", map[string]interface{}{
+			"target": "configs",
+			"error":  err.Error(),
+		})
+		return problem(c, fiber.StatusInternalServerError, "reload_failed", "Failed to reload configs: "+err.Error())
+	}
+
+	stream.Log("ADMIN_RELOAD", c.IP(), "SUCCESS", map[string]interface{}{
+		"target": "configs",
+	})
+	return AdminConfigsHandler(c)
+}
+
+// AdminAllowlistHandler lists the IPs of every allowlist along with the
+// state of its file. GET /admin/allowlist
+func AdminAllowlistHandler(c *fiber.Ctx) error {
+	states := []allowlistState{}
+	for _, allowlist := range allowlists() {
+		states = append(states, allowlistState{
+			Tenant: allowlist.tenant,
+			Status: allowlist.filter.Status(),
+			IPs:    allowlist.filter.IPs(),
+		})
+	}
+	return c.JSON(states)
+}
+
+// AdminReloadAllowlistHandler reads the allowlist files again, or only that
+// of the tenant named by ?tenant=, and answers like AdminAllowlistHandler.
+// An allowlist that cannot be read keeps its previous IPs. POST
+// /admin/allowlist/reload
+func AdminReloadAllowlistHandler(c *fiber.Ctx) error {
+	name := utils.CopyString(c.Query("tenant"))
+	if name != "" {
+		if _, found := tenant.Get(name); !found {
+			return problem(c, fiber.StatusNotFound, "tenant_not_found", "Tenant not found")
+		}
+	}
+
+	stream := audit.Default().WithContext(c.UserContext())
+	var failed []string
+	for _, allowlist := range allowlists() {
+		if name != "" && allowlist.tenant != name {
+			continue
+		}
+		file := allowlist.filter.File()
+		if err := allowlist.filter.Reload(); err != nil {
+			stream.Log("ADMIN_RELOAD", c.IP(), "FAILED", map[string]interface{}{
+				"target": "allowlist",
+				"tenant": allowlist.tenant,
+				"file":   file,
+				"error":  err.Error(),
+			})
+			failed = append(failed, file+": "+err.Error())
+			continue
+		}
+		stream.Log("ADMIN_RELOAD", c.IP(), "SUCCESS", map[string]interface{}{
+			"target": "allowlist",
+			"tenant": allowlist.tenant,
+			"file":   file,
+		})
+	}
+
+	if len(failed) > 0 {
+		return problem(c, fiber.StatusInternalServerError, "reload_failed", "Failed to reload "+strings.Join(failed, "; "))
+	}
+	return AdminAllowlistHandler(c)
+}
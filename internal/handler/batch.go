@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"strconv"
+
+	"simpleConfigServer/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxBatchItems bounds the number of keys one batch request may look up.
+const maxBatchItems = 100
+
+// batchItem is one key requested from POST /batch.
+type batchItem struct {
+	Product string `json:"product"`
+	Env     string `json:"env"`
+	Key     string `json:"key"`
+}
+
+// batchResult is the outcome of one batchItem. Status is "found",
+// "not_found", "forbidden" or "invalid_name"; Value is only set when the
+// key was found and Detail only for invalid names.
+type batchResult struct {
+	batchItem
+	Status string  `json:"status"`
+	Value  *string `json:"value,omitempty"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+// invalidName returns why the names of item are refused by the single-key
+// routes, or "" when they are valid.
+func (item batchItem) invalidName() string {
+	switch {
+	case !config.NamePattern.MatchString(item.Product):
+		return "Invalid product name " + item.Product
+	case !config.NamePattern.MatchString(item.Env):
+		return "Invalid env name " + item.Env
+	case !config.KeyPattern.MatchString(item.Key):
+		return "Invalid key name " + item.Key
+	}
+	return ""
+}
+
+// BatchHandler looks up a list of keys, possibly spread across products and
+// environments, in one request. The request passes the IP filter, rate
+// limiter and token checks once; every item is authorized and audited on
+// its own.
+func BatchHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	var items []batchItem
+	if err := c.BodyParser(&items); err != nil {
+		return problem(c, fiber.StatusBadRequest, "invalid_body", "Request body must be a JSON list of {product, env, key} objects")
+	}
+	if len(items) == 0 || len(items) > maxBatchItems {
+		return problem(c, fiber.StatusBadRequest, "batch_size", "A batch must hold between 1 and "+strconv.Itoa(maxBatchItems)+" items")
+	}
+
+	store, err := storeFor(c)
+	if store == nil {
+		return err
+	}
+
+	results := make([]batchResult, 0, len(items))
+	for _, item := range items {
+		result := batchResult{batchItem: item, Status: "not_found"}
+		product := r.tenant.Product(item.Product)
+
+		detail := item.invalidName()
+		switch {
+		case detail != "":
+			result.Status, result.Detail = "invalid_name", detail
+		case !claims.AllowsProduct(item.Product):
+			result.Status = "forbidden"
+		case !config.IsSupportedEnv(item.Env) || !store.HasProduct(product):
+			// Left as not_found
+		default:
+			envConfigs, _ := store.Configs(product, item.Env)
+			if c.Query("resolve") == "false" {
+				envConfigs, _ = store.RawConfigs(product, item.Env)
+			}
+			if value, found := envConfigs[item.Key]; found {
+				result.Status = "found"
+				result.Value = &value
+			}
+		}
+
+		status := "SUCCESS"
+		if result.Status != "found" {
+			status = "DENIED"
+		}
+		r.audit.LogConfigAccess(ip, status, item.Product, item.Env, item.Key, claims.UserID)
+		results = append(results, result)
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(results)
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"simpleConfigServer/internal/approval"
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/requestid"
+	"simpleConfigServer/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// changeRequestBody is the body of POST /{product}/changes.
+type changeRequestBody struct {
+	Environment string            `json:"environment"`
+	Set         map[string]string `json:"set"`
+	Remove      []string          `json:"remove"`
+	Comment     string            `json:"comment"`
+}
+
+// reviewBody is the body of POST /{product}/changes/{id}/reject.
+type reviewBody struct {
+	Reason string `json:"reason"`
+}
+
+// ProposeChangeHandler stores a change to a product environment as a
+// pending change request. It is applied once another principal with the
+// approver role approves it.
+func ProposeChangeHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+	product := c.Params("product")
+
+	var body changeRequestBody
+	if err := c.BodyParser(&body); err != nil {
+		r.audit.LogChangeRequest(ip, "DENIED", "", product, "", nil, claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "invalid_body", "Invalid request body")
+	}
+	env := body.Environment
+
+	if !config.IsSupportedEnv(env) {
+		r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+	store := config.GetStore()
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+	if len(body.Set) == 0 && len(body.Remove) == 0 {
+		r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "change_empty", "A change request must set or remove at least one key")
+	}
+	for key, value := range body.Set {
+		if !config.IsKeyName(key) {
+			r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "invalid_name", "Invalid key name "+key)
+		}
+		if config.HasEnvPlaceholder(value) {
+			r.audit.LogChangeRequest(ip, "DENIED", "", product, env, nil, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "invalid_value", "Value of "+key+" cannot contain ${env:...} placeholders")
+		}
+	}
+	configs, _ := store.RawConfigs(r.tenant.Product(product), env)
+	for _, key := range body.Remove {
+		if _, exists := configs[key]; !exists {
+			r.audit.LogChangeRequest(ip, "DENIED", "", product, env, body.Remove, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "key_not_found", "Cannot remove missing key "+key)
+		}
+	}
+
+	req, err := approval.Default().Propose(approval.Request{
+		Tenant:      r.tenant.Name,
+		Product:     product,
+		Environment: env,
+		Set:         body.Set,
+		Remove:      body.Remove,
+		Comment:     body.Comment,
+		ProposedBy:  claims.UserID,
+	})
+	if err != nil {
+		logger.For(r.ctx).Printf("Failed to store change request: %v", err)
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to store change request")
+	}
+	r.audit.LogChangeRequest(ip, approval.StatusPending, req.ID, product, env, req.Keys(), claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.Status(fiber.StatusCreated).JSON(maskChangeRequest(req))
+}
+
+// ListChangesHandler lists the change requests of a product, newest first,
+// optionally filtered with ?status=.
+func ListChangesHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	if !r.claims.AllowsProduct(c.Params("product")) {
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	requests := approval.Default().List(r.tenant.Name, c.Params("product"), strings.ToUpper(c.Query("status")))
+	masked := make([]*approval.Request, 0, len(requests))
+	for _, req := range requests {
+		masked = append(masked, maskChangeRequest(req))
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(masked)
+}
+
+// GetChangeHandler returns one change request of a product.
+func GetChangeHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	if !r.claims.AllowsProduct(c.Params("product")) {
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	req, found := approval.Default().Get(c.Params("id"))
+	if !found || req.Tenant != r.tenant.Name || req.Product != c.Params("product") {
+		return problem(c, fiber.StatusNotFound, "change_request_not_found", "Change request not found")
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(maskChangeRequest(req))
+}
+
+// ApproveChangeHandler applies a pending change request. The caller needs
+// the approver role and must not be the author of the request.
+func ApproveChangeHandler(c *fiber.Ctx) error {
+	r, req, err := reviewChange(c)
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	applied, err := approval.Default().Approve(req.ID, claims.UserID, func(req *approval.Request) error {
+		change := config.Change{
+			UserID:    req.ProposedBy,
+			Message:   fmt.Sprintf("Apply change request %s to %s/%s (%s), approved by %s", req.ID, req.Product, req.Environment, strings.Join(req.Keys(), ", "), claims.UserID),
+			RequestID: requestid.FromContext(r.ctx),
+		}
+		return config.UpdateConfigs(r.tenant.Product(req.Product), req.Environment, req.Set, req.Remove, change)
+	})
+	if err != nil {
+		return reviewFailed(c, r, req, err)
+	}
+	r.audit.LogChangeRequest(ip, approval.StatusApplied, applied.ID, applied.Product, applied.Environment, applied.Keys(), claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(maskChangeRequest(applied))
+}
+
+// RejectChangeHandler closes a pending change request without applying it.
+// The caller needs the approver role.
+func RejectChangeHandler(c *fiber.Ctx) error {
+	r, req, err := reviewChange(c)
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	var body reviewBody
+	c.BodyParser(&body)
+
+	rejected, err := approval.Default().Reject(req.ID, claims.UserID, body.Reason)
+	if err != nil {
+		return reviewFailed(c, r, req, err)
+	}
+	r.audit.LogChangeRequest(ip, approval.StatusRejected, rejected.ID, rejected.Product, rejected.Environment, rejected.Keys(), claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(maskChangeRequest(rejected))
+}
+
+// reviewChange authorizes a review of the change request in the path. When
+// it returns a nil request the error response has already been written.
+func reviewChange(c *fiber.Ctx) (*request, *approval.Request, error) {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return nil, nil, err
+	}
+	ip, claims := r.ip, r.claims
+	product, id := c.Params("product"), c.Params("id")
+
+	if !claims.AllowsProduct(product) {
+		r.audit.LogChangeRequest(ip, "DENIED", id, product, "", nil, claims.UserID)
+		return nil, nil, problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	req, found := approval.Default().Get(id)
+	if !found || req.Tenant != r.tenant.Name || req.Product != product {
+		r.audit.LogChangeRequest(ip, "DENIED", id, product, "", nil, claims.UserID)
+		return nil, nil, problem(c, fiber.StatusNotFound, "change_request_not_found", "Change request not found")
+	}
+	if !claims.HasRole(approval.ApproverRole) {
+		r.audit.LogChangeRequest(ip, "DENIED", id, product, req.Environment, req.Keys(), claims.UserID)
+		return nil, nil, problem(c, fiber.StatusForbidden, "role_required", "Reviewing change requests requires the "+approval.ApproverRole+" role")
+	}
+	return r, req, nil
+}
+
+// reviewFailed writes the response for an approval or rejection the store
+// refused.
+func reviewFailed(c *fiber.Ctx, r *request, req *approval.Request, err error) error {
+	r.audit.LogChangeRequest(r.ip, "DENIED", req.ID, req.Product, req.Environment, req.Keys(), r.claims.UserID)
+	switch {
+	case errors.Is(err, approval.ErrSelfApproval):
+		return problem(c, fiber.StatusForbidden, "self_approval", err.Error())
+	case errors.Is(err, approval.ErrNotPending):
+		return problem(c, fiber.StatusConflict, "not_pending", err.Error())
+	case errors.Is(err, approval.ErrNotFound):
+		return problem(c, fiber.StatusNotFound, "change_request_not_found", "Change request not found")
+	}
+	logger.For(r.ctx).Printf("Failed to apply change request %s: %v", req.ID, err)
+	return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to apply change request")
+}
+
+// maskChangeRequest hides the values of secret keys in responses.
+func maskChangeRequest(req *approval.Request) *approval.Request {
+	masked := *req
+	masked.Set = make(map[string]string, len(req.Set))
+	for key, value := range req.Set {
+		if config.IsSecretKey(key) {
+			value = config.MaskedValue
+		}
+		masked.Set[key] = value
+	}
+	return &masked
+}
+
+// ExpireChangeRequests marks change requests that were not reviewed in
+// time as expired, checking every interval. It blocks forever.
+func ExpireChangeRequests(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, req := range approval.Default().Expire() {
+			tenantNamed(req.Tenant).Audit.LogChangeRequest("SYSTEM", approval.StatusExpired, req.ID, req.Product, req.Environment, req.Keys(), req.ProposedBy)
+		}
+	}
+}
+
+// tenantNamed returns the tenant stored work such as change requests belongs
+// to, falling back to the default tenant.
+func tenantNamed(name string) *tenant.Tenant {
+	if t, ok := tenant.Get(name); ok && name != "" {
+		return t
+	}
+	return tenant.Default()
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"simpleConfigServer/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DiffHandler compares two environments of a product:
+// GET /{product}/diff?from=staging&to=production
+func DiffHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	product, from, to := c.Params("product"), c.Query("from"), c.Query("to")
+
+	if from == "" || to == "" {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "env_required", "Both from and to environments are required")
+	}
+	if !config.IsSupportedEnv(from) || !config.IsSupportedEnv(to) {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+
+	store, err := storeFor(c)
+	if store == nil {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return err
+	}
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+
+	diff, err := config.DiffEnvironments(store, r.tenant.Product(product), from, to, c.Query("resolve") == "false")
+	if err != nil {
+		r.audit.LogConfigDiff(ip, "DENIED", product, from, to, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_not_found", err.Error())
+	}
+	diff.Product = product
+
+	r.audit.LogConfigDiff(ip, "SUCCESS", product, from, to, claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(diff)
+}
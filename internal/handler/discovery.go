@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// page is one page of a listing. Next is passed as ?after= to fetch the
+// following page and is empty on the last page.
+type page struct {
+	Items []string `json:"items"`
+	Next  string   `json:"next,omitempty"`
+}
+
+// ProductsHandler lists the products the caller may read: GET /
+func ProductsHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	store, err := storeFor(c)
+	if store == nil {
+		r.audit.LogConfigList(r.ip, "DENIED", "*", "", r.claims.UserID)
+		return err
+	}
+
+	var products []string
+	for _, stored := range store.Products() {
+		if product, ok := r.tenant.ProductName(stored); ok && r.claims.AllowsProduct(product) {
+			products = append(products, product)
+		}
+	}
+	return sendPage(c, r, "*", "", products)
+}
+
+// EnvironmentsHandler lists the environments of a product: GET /{product}
+func EnvironmentsHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	product := c.Params("product")
+
+	store, err := storeFor(c)
+	if store == nil {
+		r.audit.LogConfigList(r.ip, "DENIED", product, "*", r.claims.UserID)
+		return err
+	}
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogConfigList(r.ip, "DENIED", product, "*", r.claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogConfigList(r.ip, "DENIED", product, "*", r.claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+
+	return sendPage(c, r, product, "*", store.Environments(r.tenant.Product(product)))
+}
+
+// listKeys lists the keys of a product environment without their values:
+// GET /{product}/{env}?keys_only=true
+func listKeys(c *fiber.Ctx, r *request, product string, env string) error {
+	store, err := storeFor(c)
+	if store == nil {
+		r.audit.LogConfigList(r.ip, "DENIED", product, env, r.claims.UserID)
+		return err
+	}
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogConfigList(r.ip, "DENIED", product, env, r.claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogConfigList(r.ip, "DENIED", product, env, r.claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+
+	configs, found := store.RawConfigs(r.tenant.Product(product), env)
+	if !found {
+		r.audit.LogConfigList(r.ip, "DENIED", product, env, r.claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_not_found", "No configs for "+product+"/"+env)
+	}
+
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return sendPage(c, r, product, env, keys)
+}
+
+// sendPage writes the page of sorted names selected by ?after= and ?limit=.
+func sendPage(c *fiber.Ctx, r *request, product string, env string, names []string) error {
+	limit := defaultPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			r.audit.LogConfigList(r.ip, "DENIED", product, env, r.claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "invalid_limit", "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+		}
+	}
+
+	start := 0
+	if after := c.Query("after"); after != "" {
+		start = sort.Search(len(names), func(i int) bool { return names[i] > after })
+	}
+	end := start + limit
+	if end > len(names) {
+		end = len(names)
+	}
+
+	result := page{Items: append([]string{}, names[start:end]...)}
+	if end < len(names) {
+		result.Next = names[end-1]
+	}
+
+	r.audit.LogConfigList(r.ip, "SUCCESS", product, env, r.claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(result)
+}
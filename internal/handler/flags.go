@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"strings"
+
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/flags"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FlagHandler evaluates a feature flag for the caller and returns the
+// resolved value together with the rule that produced it.
+//
+// The rollout key is taken from ?key=, the X-Flag-Key header or, failing
+// those, the user_id claim of the token.
+func FlagHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	product, env, name := c.Params("product"), c.Params("env"), c.Params("flag")
+
+	if !config.IsSupportedEnv(env) {
+		r.audit.LogFlagEvaluation(ip, "DENIED", product, env, name, "", claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+
+	store, err := storeFor(c)
+	if store == nil {
+		r.audit.LogFlagEvaluation(ip, "DENIED", product, env, name, "", claims.UserID)
+		return err
+	}
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogFlagEvaluation(ip, "DENIED", product, env, name, "", claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogFlagEvaluation(ip, "DENIED", product, env, name, "", claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+
+	envFlags, _ := store.Flags(r.tenant.Product(product), env)
+	flag, found := envFlags[name]
+	if !found {
+		r.audit.LogFlagEvaluation(ip, "DENIED", product, env, name, "", claims.UserID)
+		return problem(c, fiber.StatusNotFound, "flag_not_found", "Flag not found")
+	}
+
+	key := c.Query("key")
+	if key == "" {
+		key = c.Get("X-Flag-Key")
+	}
+	if key == "" {
+		key = claims.UserID
+	}
+
+	result := flag.Evaluate(name, flags.Context{
+		Key: key,
+		Attribute: func(attribute string) (string, bool) {
+			switch {
+			case strings.HasPrefix(attribute, "header."):
+				value := c.Get(strings.TrimPrefix(attribute, "header."))
+				return value, value != ""
+			case strings.HasPrefix(attribute, "claim."):
+				return claims.Claim(strings.TrimPrefix(attribute, "claim."))
+			default:
+				return "", false
+			}
+		},
+	})
+
+	r.audit.LogFlagEvaluation(ip, "SUCCESS", product, env, name, result.Rule, claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(result)
+}
@@ -1,89 +1,315 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 
 	"simpleConfigServer/internal/audit"
 	"simpleConfigServer/internal/auth"
 	"simpleConfigServer/internal/config"
-	"simpleConfigServer/internal/ipfilter"
-	"simpleConfigServer/internal/rate_limiter"
+	"simpleConfigServer/internal/format"
+	"simpleConfigServer/internal/tenant"
+	"simpleConfigServer/internal/tracing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var jwtSecret = os.Getenv("JWT_SECRET")
 
-func ConfigHandler(c *fiber.Ctx) error {
+// request is a request that passed authorize, along with the tenant it
+// addresses. Its audit events go to the tenant's audit stream, stamped with
+// the request ID.
+type request struct {
+	ctx    context.Context
+	ip     string
+	claims *auth.Claims
+	tenant *tenant.Tenant
+	audit  *audit.Stream
+}
+
+// authorize runs the checks shared by every config route: IP filter, rate
+// limiter and JWT validation, using the settings of the named tenant in
+// multi-tenant mode. When it returns a nil request the error response has
+// already been written and err is what the handler should return.
+func authorize(c *fiber.Ctx, tenantName string) (*request, error) {
 	ip := c.IP()
-	audit.LogSystem("REQUEST", "START", map[string]interface{}{
+	ctx := c.UserContext()
+
+	t := tenant.Default()
+	if tenant.Enabled() {
+		var found bool
+		t, found = tenant.Get(tenantName)
+		if !found {
+			audit.Default().WithContext(ctx).LogSecurity(ip, "DENIED", "TENANT", map[string]interface{}{
+				"reason": "Unknown tenant",
+				"tenant": tenantName,
+				"path":   c.Path(),
+			})
+			return nil, problem(c, fiber.StatusNotFound, "tenant_not_found", "Tenant not found")
+		}
+	}
+
+	stream := t.Audit.WithContext(ctx)
+	stream.LogSystem("REQUEST", "START", map[string]interface{}{
 		"path": c.Path(),
 		"ip":   ip,
 	})
 
-	// Check if IP is allowed
-	if !ipfilter.IsIPAllowed(ip) {
-		audit.LogSecurity(ip, "DENIED", "IP_FILTER", map[string]interface{}{
-			"reason": "IP not in allowed list",
-		})
-		return c.Status(fiber.StatusForbidden).SendString("IP not allowed")
+	for _, name := range []string{"product", "env", "flag"} {
+		if value := c.Params(name); value != "" && !config.NamePattern.MatchString(value) {
+			stream.LogSystem("REQUEST", "INVALID", map[string]interface{}{
+				"reason": "Invalid " + name + " name",
+				"path":   c.Path(),
+			})
+			return nil, problem(c, fiber.StatusBadRequest, "invalid_name", "Invalid "+name+" name "+value)
+		}
 	}
-
-	limiter := rate_limiter.GetRateLimiter(ip)
-	if !limiter.Allow() {
-		audit.LogSecurity(ip, "DENIED", "RATE_LIMIT", map[string]interface{}{
-			"reason": "Rate limit exceeded",
+	if key := keyParam(c); key != "" && !config.KeyPattern.MatchString(key) {
+		stream.LogSystem("REQUEST", "INVALID", map[string]interface{}{
+			"reason": "Invalid key name",
+			"path":   c.Path(),
 		})
-		return c.Status(fiber.StatusTooManyRequests).SendString("Too Many Requests")
+		return nil, problem(c, fiber.StatusBadRequest, "invalid_name", "Invalid key name "+key)
 	}
 
 	tokenString := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
-	claims, isValid := auth.ValidateJWT(tokenString)
-	if !isValid {
-		audit.LogAuth(ip, "FAILED", "")
-		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+	claims, err := t.Authorize(ctx, ip, tokenString)
+	switch err {
+	case nil:
+	case tenant.ErrIPNotAllowed:
+		return nil, problem(c, fiber.StatusForbidden, "ip_not_allowed", "IP not allowed")
+	case tenant.ErrRateLimited:
+		return nil, problem(c, fiber.StatusTooManyRequests, "rate_limited", "Rate limit exceeded")
+	case auth.ErrTokenMissing:
+		return nil, problem(c, fiber.StatusUnauthorized, "token_missing", "Unauthorized: "+err.Error())
+	case auth.ErrTokenExpired:
+		return nil, problem(c, fiber.StatusUnauthorized, "token_expired", "Unauthorized: "+err.Error())
+	default:
+		return nil, problem(c, fiber.StatusUnauthorized, "token_invalid", "Unauthorized: "+err.Error())
 	}
-	audit.LogAuth(ip, "SUCCESS", claims.UserID)
 
-	vars := strings.Split(c.Path(), "/")
-	if len(vars) < 4 {
-		audit.LogSystem("REQUEST", "INVALID", map[string]interface{}{
-			"reason": "Invalid request path",
-			"path":   c.Path(),
-		})
-		return c.Status(fiber.StatusBadRequest).SendString("Invalid request path")
+	return &request{ctx: ctx, ip: ip, claims: claims, tenant: t, audit: stream}, nil
+}
+
+// keyParam returns the key in the request path, URL-decoded so that glob
+// patterns can use an escaped "?".
+func keyParam(c *fiber.Ctx) string {
+	key := c.Params("key")
+	if unescaped, err := url.PathUnescape(key); err == nil {
+		return unescaped
 	}
+	return key
+}
+
+// escapeGlob quotes the characters path.Match treats specially.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
 
-	product, env, configKey := vars[1], vars[2], vars[3]
+// setSecurityHeaders sets the headers sent with every config response
+func setSecurityHeaders(c *fiber.Ctx) {
+	c.Set("Content-Security-Policy", "default-src 'self'")
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("X-Frame-Options", "DENY")
+	c.Set("X-XSS-Protection", "1; mode=block")
+}
 
-	if env != "staging" && env != "production" && env != "development" {
-		audit.LogConfigAccess(ip, "DENIED", product, env, configKey, claims.UserID)
-		return c.Status(fiber.StatusNotFound).SendString("Environment not supported")
+// storeFor returns the configs a request reads from: the loaded ones, or
+// those as of ?ref= when the source keeps history. The revision served is
+// reported in the X-Config-Revision header. When it returns a nil store the
+// error response has already been written.
+func storeFor(c *fiber.Ctx) (config.Store, error) {
+	ref := c.Query("ref")
+	if ref == "" {
+		if revision := config.GetRevision(); revision != "" {
+			c.Set("X-Config-Revision", revision)
+		}
+		return config.GetStore(), nil
 	}
 
-	configs := config.GetConfigs()
-	productConfigs, exists := configs[product]
-	if !exists {
-		audit.LogConfigAccess(ip, "DENIED", product, env, configKey, claims.UserID)
-		return c.Status(fiber.StatusNotFound).SendString("Product not found")
+	store, revision, err := config.GetStoreAt(ref)
+	if errors.Is(err, config.ErrNotVersioned) {
+		return nil, problem(c, fiber.StatusBadRequest, "revision_unsupported", "Config source does not support ?ref=")
 	}
+	if err != nil {
+		return nil, problem(c, fiber.StatusNotFound, "revision_not_found", "Revision not found")
+	}
+	c.Set("X-Config-Revision", revision)
+	return store, nil
+}
 
-	configValue, found := productConfigs[configKey]
-	if !found {
-		audit.LogConfigAccess(ip, "DENIED", product, env, configKey, claims.UserID)
-		return c.Status(fiber.StatusNotFound).SendString("Configs not found")
+// ConfigHandler serves /{product}/{env}/{key}, or /{product}/{env} for
+// every key of the environment.
+func ConfigHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
 	}
+	product, env, configKey := c.Params("product"), c.Params("env"), keyParam(c)
 
-	audit.LogConfigAccess(ip, "SUCCESS", product, env, configKey, claims.UserID)
-	response := map[string]string{configKey: configValue}
+	// A key containing glob characters, or ?prefix= in place of a key,
+	// selects every matching key. The pattern is audited as the key.
+	pattern := ""
+	if strings.ContainsAny(configKey, "*?[") {
+		pattern, configKey = configKey, ""
+	} else if prefix := c.Query("prefix"); configKey == "" && prefix != "" {
+		pattern = escapeGlob(prefix) + "*"
+	}
+	accessKey := configKey
+	if pattern != "" {
+		accessKey = pattern
+	}
+	if accessKey == "" {
+		accessKey = "*"
+	}
+
+	if !config.IsSupportedEnv(env) {
+		r.logAccess("DENIED", product, env, accessKey)
+		return problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		r.logAccess("DENIED", product, env, accessKey)
+		return problem(c, fiber.StatusBadRequest, "invalid_pattern", "Invalid key pattern")
+	}
+	if configKey == "" && pattern == "" && c.QueryBool("keys_only") {
+		return listKeys(c, r, product, env)
+	}
+
+	formatName := c.Query("format")
+	if c.QueryBool("raw") {
+		formatName = "raw"
+	}
+	encoder, ok := format.Negotiate(formatName, c.Get(fiber.HeaderAccept))
+	if !ok {
+		r.logAccess("DENIED", product, env, accessKey)
+		return problem(c, fiber.StatusNotAcceptable, "format_unsupported", "Supported formats: "+strings.Join(format.Names(), ", "))
+	}
+	if _, single := encoder.(format.SingleValueEncoder); single && configKey == "" {
+		r.logAccess("DENIED", product, env, accessKey)
+		return problem(c, fiber.StatusBadRequest, "key_required", "Raw output requires a config key")
+	}
+
+	_, canonical := encoder.(format.CanonicalEncoder)
+	response, err := lookup(c, r, product, env, configKey, pattern, accessKey, canonical)
+	if response == nil {
+		return err
+	}
+
+	body, err := encoder.Encode(response)
+	if errors.Is(err, format.ErrNameCollision) {
+		r.logAccess("DENIED", product, env, accessKey)
+		return problem(c, fiber.StatusNotAcceptable, "key_collision", err.Error()+"; select the keys or use another format")
+	}
+	if err != nil {
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to encode configs")
+	}
+
+	r.logAccess("SUCCESS", product, env, accessKey)
 
 	// Set security headers
-	c.Set("Content-Type", "application/json")
-	c.Set("Content-Security-Policy", "default-src 'self'")
-	c.Set("X-Content-Type-Options", "nosniff")
-	c.Set("X-Frame-Options", "DENY")
-	c.Set("X-XSS-Protection", "1; mode=block")
+	c.Set("Content-Type", encoder.ContentType())
+	setSecurityHeaders(c)
+	c.Set(fiber.HeaderVary, fiber.HeaderAccept)
+
+	return c.Send(body)
+}
+
+// lookup returns the values a ConfigHandler request selects: every key of
+// the environment, the keys matching pattern or the single configKey. With
+// canonical, typed values are returned in their canonical spelling. When it
+// returns nil the error response has already been written.
+func lookup(c *fiber.Ctx, r *request, product string, env string, configKey string, pattern string, accessKey string, canonical bool) (map[string]string, error) {
+	// Spans are exported after fiber reuses the buffers params point into
+	ctx, span := tracing.Start(r.ctx, "config.lookup",
+		attribute.String("config.product", utils.CopyString(product)),
+		attribute.String("config.env", utils.CopyString(env)),
+		attribute.String("config.key", utils.CopyString(accessKey)))
+	defer span.End()
+	r = r.within(ctx)
+
+	store, err := storeFor(c)
+	if store == nil {
+		r.logAccess("DENIED", product, env, accessKey)
+		return nil, err
+	}
+	if !r.claims.AllowsProduct(product) {
+		r.logAccess("DENIED", product, env, accessKey)
+		return nil, problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.logAccess("DENIED", product, env, accessKey)
+		return nil, problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+
+	// Placeholders are resolved unless the caller asks for the values as written
+	envConfigs, found := store.Configs(r.tenant.Product(product), env)
+	if c.Query("resolve") == "false" {
+		envConfigs, found = store.RawConfigs(r.tenant.Product(product), env)
+	}
+	if !found {
+		r.logAccess("DENIED", product, env, accessKey)
+		return nil, problem(c, fiber.StatusNotFound, "env_not_found", "No configs for "+product+"/"+env)
+	}
+	response := envConfigs
+	if configKey != "" {
+		var configValue string
+		configValue, found = envConfigs[configKey]
+		response = map[string]string{configKey: configValue}
+	} else if pattern != "" {
+		response = make(map[string]string)
+		for key, value := range envConfigs {
+			if matched, _ := path.Match(pattern, key); matched {
+				response[key] = value
+			}
+		}
+	}
+	if !found {
+		r.logAccess("DENIED", product, env, accessKey)
+		return nil, problem(c, fiber.StatusNotFound, "key_not_found", "Key "+configKey+" not found in "+product+"/"+env)
+	}
+
+	if canonical {
+		// Typed values never hold placeholders, so the spelling applies
+		// whether or not placeholders were resolved
+		spellings := store.CanonicalConfigs(r.tenant.Product(product), env)
+		canonicalized := make(map[string]string, len(response))
+		for key, value := range response {
+			if spelling, ok := spellings[key]; ok {
+				value = spelling
+			}
+			canonicalized[key] = value
+		}
+		response = canonicalized
+	}
+
+	span.SetAttributes(attribute.Int("config.keys", len(response)))
+	return response, nil
+}
+
+// within returns a copy of r whose spans are started below ctx.
+func (r *request) within(ctx context.Context) *request {
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
 
-	return c.JSON(response)
+// logAccess audits access to configs in a span of its own, so that slow
+// audit writes show up in traces.
+func (r *request) logAccess(status string, product string, env string, key string) {
+	_, span := tracing.Start(r.ctx, "audit.write", attribute.String("audit.status", status))
+	defer span.End()
+	r.audit.LogConfigAccess(r.ip, status, product, env, key, r.claims.UserID)
 }
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/ipfilter"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readiness is the body of /readyz: the state of the config loader and of
+// every allowlist, the server wide one first.
+type readiness struct {
+	Ready      bool              `json:"ready"`
+	Configs    config.Status     `json:"configs"`
+	AllowedIPs []ipfilter.Status `json:"allowed_ips"`
+}
+
+// HealthHandler reports that the process is alive: GET /healthz. Probes
+// need no token and are neither filtered, rate limited nor audited.
+func HealthHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// ReadyHandler reports whether the server can serve configs: GET /readyz.
+// It answers 503 until the configs and allowlists have been loaded and their
+// watchers started, and while the config source cannot be read.
+func ReadyHandler(c *fiber.Ctx) error {
+	status := readiness{Configs: config.GetStatus()}
+	status.Ready = status.Configs.Loaded && status.Configs.Watching && status.Configs.Error == ""
+
+	for _, allowlist := range allowlists() {
+		filterStatus := allowlist.filter.Status()
+		status.Ready = status.Ready && filterStatus.Loaded && filterStatus.Watching
+		status.AllowedIPs = append(status.AllowedIPs, filterStatus)
+	}
+
+	if !status.Ready {
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(status)
+}
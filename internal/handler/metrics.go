@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"simpleConfigServer/internal/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// RecordMetrics is middleware that counts every request by the route
+// pattern it matched and observes its latency. Requests matching no route
+// are counted as route "unmatched".
+func RecordMetrics(c *fiber.Ctx) error {
+	start := time.Now()
+
+	// Errors are handled here so that the status they lead to is recorded
+	if err := c.Next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	// Unmatched requests are left with the route of the last middleware
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		route = "unmatched"
+	}
+	// The method is backed by the request buffer, which fiber reuses
+	method := utils.CopyString(c.Method())
+	metrics.ObserveRequest(route, method, c.Response().StatusCode(), time.Since(start))
+	return nil
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format: GET
+// /metrics. When token is set, scrapes must send it as a bearer token.
+// Scrapes are neither filtered, rate limited nor audited.
+func MetricsHandler(token string) fiber.Handler {
+	serve := adaptor.HTTPHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	return func(c *fiber.Ctx) error {
+		if token != "" {
+			if code, detail := checkBearer(c, token); code != "" {
+				return problem(c, fiber.StatusUnauthorized, code, detail)
+			}
+		}
+		return serve(c)
+	}
+}
+
+// checkBearer compares the bearer token of a request with token in
+// constant time. It returns the problem code and detail to answer with
+// when they differ, and an empty code when they match.
+func checkBearer(c *fiber.Ctx, token string) (string, string) {
+	sent := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if sent == "" {
+		return "token_missing", "Unauthorized: no token"
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		return "token_invalid", "Unauthorized: invalid token"
+	}
+	return "", ""
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openAPISpec describes every route added by Register. It is kept by hand;
+// openapi_test.go fails when a route is missing from it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPIHandler serves the OpenAPI document of the API. It needs no token
+// so clients can be generated from it.
+func OpenAPIHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(openAPISpec)
+}
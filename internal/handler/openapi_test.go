@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	app := fiber.New()
+	RegisterAll(app, "token", "token")
+
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		// Config paths are documented relative to the /v1 server
+		path := strings.TrimPrefix(route.Path, "/v1")
+		if path == "" {
+			path = "/"
+		}
+		path = routeParam.ReplaceAllString(path, "{$1}")
+		if path != "/" {
+			path = strings.TrimSuffix(path, "/")
+		}
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is not documented in openapi.json", route.Method, path)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"simpleConfigServer/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProblemContentType is the media type of error responses, see RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the code of a problem to form its type URI.
+const problemTypeBase = "urn:simple-config-server:problem:"
+
+// problem writes an RFC 7807 error response. Code is a stable, machine
+// readable identifier such as "product_not_found"; detail is meant for
+// people. Members of extra, e.g. the current plan of a refused promotion,
+// are added to the response.
+func problem(c *fiber.Ctx, status int, code string, detail string, extra ...map[string]interface{}) error {
+	body := map[string]interface{}{
+		"type":     problemTypeBase + code,
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   detail,
+		"instance": c.OriginalURL(),
+		"code":     code,
+	}
+	if requestID := c.GetRespHeader(fiber.HeaderXRequestID); requestID != "" {
+		body["request_id"] = requestID
+	}
+	for _, members := range extra {
+		for name, value := range members {
+			body[name] = value
+		}
+	}
+
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	setSecurityHeaders(c)
+	c.Set(fiber.HeaderContentType, ProblemContentType)
+	return c.Status(status).Send(bytes)
+}
+
+// ErrorHandler turns errors returned by routes and middleware, including
+// unknown routes and recovered panics, into problem responses.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		switch fiberErr.Code {
+		case fiber.StatusNotFound:
+			return problem(c, fiberErr.Code, "route_not_found", "No route matches "+c.Method()+" "+c.Path())
+		case fiber.StatusMethodNotAllowed:
+			return problem(c, fiberErr.Code, "method_not_allowed", "Method "+c.Method()+" is not allowed on "+c.Path())
+		case fiber.StatusRequestEntityTooLarge:
+			return problem(c, fiberErr.Code, "body_too_large", fiberErr.Message)
+		}
+		if fiberErr.Code < fiber.StatusInternalServerError {
+			return problem(c, fiberErr.Code, "bad_request", fiberErr.Message)
+		}
+	}
+
+	logger.For(c.UserContext()).Printf("Error handling %s %s: %v", c.Method(), c.Path(), err)
+	return problem(c, fiber.StatusInternalServerError, "internal_error", "The server failed to handle the request")
+}
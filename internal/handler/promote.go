@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"simpleConfigServer/internal/approval"
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/requestid"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// promoteRequest is the body of POST /{product}/promote. Plan is the ID of
+// the plan returned by GET /{product}/promote for the same keys.
+type promoteRequest struct {
+	From string   `json:"from"`
+	To   string   `json:"to"`
+	Keys []string `json:"keys"`
+	Plan string   `json:"plan"`
+}
+
+// PromotePlanHandler shows what promoting keys from one environment to
+// another would change, without changing anything:
+// GET /{product}/promote?from=staging&to=production[&keys=a,b]
+func PromotePlanHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	var keys []string
+	if c.Query("keys") != "" {
+		keys = strings.Split(c.Query("keys"), ",")
+	}
+	req := promoteRequest{From: c.Query("from"), To: c.Query("to"), Keys: keys}
+
+	product := c.Params("product")
+	if ok, err := checkPromotion(c, r, product, req); !ok {
+		return err
+	}
+
+	plan, err := config.PlanPromotion(config.GetStore(), r.tenant.Product(product), req.From, req.To, req.Keys)
+	if err != nil {
+		logPromotion(r, "DENIED", product, req, err.Error())
+		return problem(c, fiber.StatusBadRequest, "promotion_invalid", err.Error())
+	}
+	plan.Product = product
+
+	logPromotion(r, "PLANNED", product, req, plan.ID)
+
+	setSecurityHeaders(c)
+	return c.JSON(plan)
+}
+
+// PromoteHandler applies a plan generated by PromotePlanHandler. The plan is
+// refused with 409 Conflict, along with the current plan, when either
+// environment changed since it was generated. Promotions to the protected
+// environment are proposed as a change request instead, see
+// proposePromotion.
+func PromoteHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+
+	var req promoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return problem(c, fiber.StatusBadRequest, "invalid_body", "Invalid request body")
+	}
+
+	product := c.Params("product")
+	if ok, err := checkPromotion(c, r, product, req); !ok {
+		return err
+	}
+	if req.Plan == "" {
+		logPromotion(r, "DENIED", product, req, "missing plan")
+		return problem(c, fiber.StatusBadRequest, "plan_required", "A plan ID is required; generate one with GET "+c.Path())
+	}
+	if req.To == approval.ProtectedEnvironment {
+		return proposePromotion(c, r, product, req)
+	}
+
+	change := config.Change{UserID: claims.UserID, RequestID: requestid.FromContext(r.ctx)}
+	plan, err := config.ApplyPromotion(config.GetSource(), r.tenant.Product(product), req.From, req.To, req.Keys, req.Plan, change)
+	if plan != nil {
+		plan.Product = product
+	}
+	switch {
+	case errors.Is(err, config.ErrPlanStale):
+		logPromotion(r, "REJECTED", product, req, err.Error())
+		return problem(c, fiber.StatusConflict, "plan_stale", err.Error(), map[string]interface{}{"plan": plan})
+	case plan == nil:
+		logPromotion(r, "DENIED", product, req, err.Error())
+		return problem(c, fiber.StatusBadRequest, "promotion_invalid", err.Error())
+	case err != nil:
+		logPromotion(r, "FAILED", product, req, err.Error())
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to apply promotion")
+	}
+
+	for _, key := range plan.ChangedKeys() {
+		r.audit.LogConfigChange(ip, "PROMOTED", product, req.To, key, plan.Changes[key].From, plan.Changes[key].To, claims.UserID)
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(plan)
+}
+
+// proposePromotion stores a promotion to the protected environment as a
+// pending change request setting the promoted values, so that it is only
+// applied once another approver approves it. Approvers cannot promote to
+// it on their own.
+func proposePromotion(c *fiber.Ctx, r *request, product string, req promoteRequest) error {
+	plan, err := config.PlanPromotion(config.GetStore(), r.tenant.Product(product), req.From, req.To, req.Keys)
+	if err != nil {
+		logPromotion(r, "DENIED", product, req, err.Error())
+		return problem(c, fiber.StatusBadRequest, "promotion_invalid", err.Error())
+	}
+	plan.Product = product
+	if plan.ID != req.Plan {
+		logPromotion(r, "REJECTED", product, req, config.ErrPlanStale.Error())
+		return problem(c, fiber.StatusConflict, "plan_stale", config.ErrPlanStale.Error(), map[string]interface{}{"plan": plan})
+	}
+	if plan.Empty() {
+		setSecurityHeaders(c)
+		return c.JSON(plan)
+	}
+
+	cr, err := approval.Default().Propose(approval.Request{
+		Tenant:      r.tenant.Name,
+		Product:     product,
+		Environment: req.To,
+		Set:         plan.Values(),
+		Comment:     fmt.Sprintf("Promote %s/%s to %s", product, req.From, req.To),
+		ProposedBy:  r.claims.UserID,
+	})
+	if err != nil {
+		logger.For(r.ctx).Printf("Failed to store change request: %v", err)
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to store change request")
+	}
+	logPromotion(r, "PROPOSED", product, req, cr.ID)
+	r.audit.LogChangeRequest(r.ip, approval.StatusPending, cr.ID, product, req.To, cr.Keys(), r.claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.Status(fiber.StatusAccepted).JSON(maskChangeRequest(cr))
+}
+
+// checkPromotion validates the environments and product of a promotion.
+// When it returns false the error response has already been written.
+func checkPromotion(c *fiber.Ctx, r *request, product string, req promoteRequest) (bool, error) {
+	if req.From == "" || req.To == "" {
+		logPromotion(r, "DENIED", product, req, "missing environment")
+		return false, problem(c, fiber.StatusBadRequest, "env_required", "Both from and to environments are required")
+	}
+	if !config.IsSupportedEnv(req.From) || !config.IsSupportedEnv(req.To) {
+		logPromotion(r, "DENIED", product, req, "unsupported environment")
+		return false, problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+	if !r.claims.AllowsProduct(product) {
+		logPromotion(r, "DENIED", product, req, "product not allowed")
+		return false, problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !config.GetStore().HasProduct(r.tenant.Product(product)) {
+		logPromotion(r, "DENIED", product, req, "unknown product")
+		return false, problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+	return true, nil
+}
+
+func logPromotion(r *request, status string, product string, req promoteRequest, detail string) {
+	r.audit.LogSystem("CONFIG_PROMOTE", status, map[string]interface{}{
+		"ip":      r.ip,
+		"product": product,
+		"from":    req.From,
+		"to":      req.To,
+		"keys":    req.Keys,
+		"user_id": r.claims.UserID,
+		"detail":  detail,
+	})
+}
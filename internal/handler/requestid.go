@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"simpleConfigServer/internal/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// RequestID is middleware that gives every request an ID: the one sent in
+// X-Request-ID when it is valid, a new one otherwise. The ID is returned in
+// the response header and carried in the user context, from where logs and
+// audit events pick it up.
+func RequestID(c *fiber.Ctx) error {
+	// The header is backed by the request buffer, which fiber reuses
+	id := requestid.Accept(utils.CopyString(c.Get(requestid.Header)))
+	c.Set(requestid.Header, id)
+	c.SetUserContext(requestid.NewContext(c.UserContext(), id))
+	return c.Next()
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"simpleConfigServer/internal/tenant"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterAll adds every route of the server to app: the probes, the
+// metrics scrape, the admin routes when adminToken is set and the config
+// routes. Probes, scrapes and admin routes come first so that they are not
+// taken for product names. Unversioned config paths are kept as an alias of
+// /v1 for existing clients.
+func RegisterAll(app *fiber.App, metricsToken string, adminToken string) {
+	app.Get("/healthz", HealthHandler)
+	app.Get("/readyz", ReadyHandler)
+	app.Get("/metrics", MetricsHandler(metricsToken))
+	if adminToken != "" {
+		RegisterAdmin(app.Group("/admin"), adminToken)
+	}
+
+	Register(app.Group("/v1"))
+	Register(app)
+}
+
+// Register adds every config route to router, below /{tenant} in
+// multi-tenant mode. Routes with fixed segments, e.g. /{product}/diff, are
+// registered before the {product}/{env} routes they would otherwise be
+// taken for.
+func Register(router fiber.Router) {
+	router.Get("/openapi.json", OpenAPIHandler)
+
+	if tenant.Enabled() {
+		router = router.Group("/:tenant")
+	}
+
+	router.Get("/", ProductsHandler)
+	router.Post("/batch", BatchHandler)
+
+	router.Get("/:product/diff", DiffHandler)
+	router.Get("/:product/promote", PromotePlanHandler)
+	router.Post("/:product/promote", PromoteHandler)
+	router.Post("/:product/changes", ProposeChangeHandler)
+	router.Get("/:product/changes", ListChangesHandler)
+	router.Get("/:product/changes/:id", GetChangeHandler)
+	router.Post("/:product/changes/:id/approve", ApproveChangeHandler)
+	router.Post("/:product/changes/:id/reject", RejectChangeHandler)
+	router.Post("/:product/schedules", ScheduleChangeHandler)
+	router.Get("/:product/schedules", ListSchedulesHandler)
+	router.Get("/:product/schedules/:id", GetScheduleHandler)
+	router.Delete("/:product/schedules/:id", CancelScheduleHandler)
+	router.Post("/:product/schedules/:id/approve", ApproveScheduleHandler)
+	router.Get("/:product/:env/flags/:flag", FlagHandler)
+
+	router.Get("/:product", EnvironmentsHandler)
+	router.Get("/:product/:env", ConfigHandler)
+	router.Get("/:product/:env/:key", ConfigHandler)
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"simpleConfigServer/internal/approval"
+	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/schedule"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// scheduleBody is the body of POST /{product}/schedules.
+type scheduleBody struct {
+	Environment string            `json:"environment"`
+	Set         map[string]string `json:"set"`
+	Remove      []string          `json:"remove"`
+	Comment     string            `json:"comment"`
+	EffectiveAt time.Time         `json:"effective_at"`
+}
+
+// ScheduleChangeHandler schedules a change to a product environment that
+// the server applies at effective_at, an RFC 3339 timestamp. Changes to the
+// protected environment are only applied once another principal with the
+// approver role approved them.
+func ScheduleChangeHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+	product := c.Params("product")
+
+	var body scheduleBody
+	if err := c.BodyParser(&body); err != nil {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, "", nil, "", claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "invalid_body", "Invalid request body; effective_at must be an RFC 3339 timestamp")
+	}
+	env, effectiveAt := body.Environment, body.EffectiveAt.UTC().Format(time.RFC3339)
+
+	if !config.IsSupportedEnv(env) {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "env_unsupported", "Environment not supported")
+	}
+	store := config.GetStore()
+	if !r.claims.AllowsProduct(product) {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+	if !store.HasProduct(r.tenant.Product(product)) {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusNotFound, "product_not_found", "Product not found")
+	}
+	if len(body.Set) == 0 && len(body.Remove) == 0 {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "change_empty", "A scheduled change must set or remove at least one key")
+	}
+	for key, value := range body.Set {
+		if !config.IsKeyName(key) {
+			r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "invalid_name", "Invalid key name "+key)
+		}
+		if config.HasEnvPlaceholder(value) {
+			r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "invalid_value", "Value of "+key+" cannot contain ${env:...} placeholders")
+		}
+	}
+	if !body.EffectiveAt.After(time.Now()) {
+		r.audit.LogScheduledChange(ip, "DENIED", "", product, env, nil, effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusBadRequest, "invalid_effective_at", "effective_at must be in the future")
+	}
+	configs, _ := store.RawConfigs(r.tenant.Product(product), env)
+	for _, key := range body.Remove {
+		if _, exists := configs[key]; !exists {
+			r.audit.LogScheduledChange(ip, "DENIED", "", product, env, body.Remove, effectiveAt, claims.UserID)
+			return problem(c, fiber.StatusBadRequest, "key_not_found", "Cannot remove missing key "+key)
+		}
+	}
+
+	change, err := schedule.Default().Add(schedule.Change{
+		Tenant:        r.tenant.Name,
+		Product:       product,
+		Environment:   env,
+		Set:           body.Set,
+		Remove:        body.Remove,
+		Comment:       body.Comment,
+		EffectiveAt:   body.EffectiveAt,
+		ScheduledBy:   claims.UserID,
+		NeedsApproval: env == approval.ProtectedEnvironment,
+	})
+	if err != nil {
+		logger.For(r.ctx).Printf("Failed to store scheduled change: %v", err)
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to store scheduled change")
+	}
+	r.audit.LogScheduledChange(ip, change.Status, change.ID, product, env, change.Keys(), effectiveAt, claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.Status(fiber.StatusCreated).JSON(maskScheduledChange(change))
+}
+
+// ApproveScheduleHandler approves a scheduled change pending approval. The
+// caller needs the approver role and must not be the user who scheduled
+// it.
+func ApproveScheduleHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+	product, id := c.Params("product"), c.Params("id")
+
+	if !claims.AllowsProduct(product) {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, "", nil, "", claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	change, found := schedule.Default().Get(id)
+	if !found || change.Tenant != r.tenant.Name || change.Product != product {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, "", nil, "", claims.UserID)
+		return problem(c, fiber.StatusNotFound, "schedule_not_found", "Scheduled change not found")
+	}
+	effectiveAt := change.EffectiveAt.Format(time.RFC3339)
+	if !claims.HasRole(approval.ApproverRole) {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, change.Environment, change.Keys(), effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusForbidden, "role_required", "Approving scheduled changes requires the "+approval.ApproverRole+" role")
+	}
+
+	approved, err := schedule.Default().Approve(id, claims.UserID)
+	if err != nil {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, change.Environment, change.Keys(), effectiveAt, claims.UserID)
+		switch {
+		case errors.Is(err, schedule.ErrSelfApproval):
+			return problem(c, fiber.StatusForbidden, "self_approval", err.Error())
+		case errors.Is(err, schedule.ErrNotPending):
+			return problem(c, fiber.StatusConflict, "not_pending", err.Error())
+		}
+		logger.For(r.ctx).Printf("Failed to approve scheduled change %s: %v", id, err)
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to approve scheduled change")
+	}
+	r.audit.LogScheduledChange(ip, "APPROVED", id, product, approved.Environment, approved.Keys(), effectiveAt, claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(maskScheduledChange(approved))
+}
+
+// ListSchedulesHandler lists the scheduled changes of a product, the
+// earliest effective first, optionally filtered with ?status=.
+func ListSchedulesHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	if !r.claims.AllowsProduct(c.Params("product")) {
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	changes := schedule.Default().List(r.tenant.Name, c.Params("product"), strings.ToUpper(c.Query("status")))
+	masked := make([]*schedule.Change, 0, len(changes))
+	for _, change := range changes {
+		masked = append(masked, maskScheduledChange(change))
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(masked)
+}
+
+// GetScheduleHandler returns one scheduled change of a product.
+func GetScheduleHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+
+	if !r.claims.AllowsProduct(c.Params("product")) {
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	change, found := schedule.Default().Get(c.Params("id"))
+	if !found || change.Tenant != r.tenant.Name || change.Product != c.Params("product") {
+		return problem(c, fiber.StatusNotFound, "schedule_not_found", "Scheduled change not found")
+	}
+
+	setSecurityHeaders(c)
+	return c.JSON(maskScheduledChange(change))
+}
+
+// CancelScheduleHandler withdraws a change before it takes effect. Only the
+// user who scheduled it or an approver may cancel it.
+func CancelScheduleHandler(c *fiber.Ctx) error {
+	r, err := authorize(c, c.Params("tenant"))
+	if r == nil {
+		return err
+	}
+	ip, claims := r.ip, r.claims
+	product, id := c.Params("product"), c.Params("id")
+
+	if !claims.AllowsProduct(product) {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, "", nil, "", claims.UserID)
+		return problem(c, fiber.StatusForbidden, "product_forbidden", "Product not allowed")
+	}
+
+	change, found := schedule.Default().Get(id)
+	if !found || change.Tenant != r.tenant.Name || change.Product != product {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, "", nil, "", claims.UserID)
+		return problem(c, fiber.StatusNotFound, "schedule_not_found", "Scheduled change not found")
+	}
+	effectiveAt := change.EffectiveAt.Format(time.RFC3339)
+	if change.ScheduledBy != claims.UserID && !claims.HasRole(approval.ApproverRole) {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, change.Environment, change.Keys(), effectiveAt, claims.UserID)
+		return problem(c, fiber.StatusForbidden, "forbidden", "Only the user who scheduled a change or an approver can cancel it")
+	}
+
+	cancelled, err := schedule.Default().Cancel(id, claims.UserID)
+	if err != nil {
+		r.audit.LogScheduledChange(ip, "DENIED", id, product, change.Environment, change.Keys(), effectiveAt, claims.UserID)
+		if errors.Is(err, schedule.ErrNotScheduled) {
+			return problem(c, fiber.StatusConflict, "not_scheduled", err.Error())
+		}
+		logger.For(r.ctx).Printf("Failed to cancel scheduled change %s: %v", id, err)
+		return problem(c, fiber.StatusInternalServerError, "internal_error", "Failed to cancel scheduled change")
+	}
+	r.audit.LogScheduledChange(ip, schedule.StatusCancelled, id, product, cancelled.Environment, cancelled.Keys(), effectiveAt, claims.UserID)
+
+	setSecurityHeaders(c)
+	return c.JSON(maskScheduledChange(cancelled))
+}
+
+// maskScheduledChange hides the values of secret keys in responses.
+func maskScheduledChange(change *schedule.Change) *schedule.Change {
+	masked := *change
+	masked.Set = make(map[string]string, len(change.Set))
+	for key, value := range change.Set {
+		if config.IsSecretKey(key) {
+			value = config.MaskedValue
+		}
+		masked.Set[key] = value
+	}
+	return &masked
+}
+
+// ApplyScheduledChanges applies scheduled changes once they take effect,
+// checking every interval. Changes that fell due while the server was down
+// are applied on the first check. It blocks forever.
+func ApplyScheduledChanges(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		processed := schedule.Default().ApplyDue(func(change *schedule.Change) error {
+			t := tenantNamed(change.Tenant)
+			return config.UpdateConfigs(t.Product(change.Product), change.Environment, change.Set, change.Remove, config.Change{
+				UserID:  change.ScheduledBy,
+				Message: fmt.Sprintf("Apply scheduled change %s to %s/%s (%s), effective %s", change.ID, change.Product, change.Environment, strings.Join(change.Keys(), ", "), change.EffectiveAt.Format(time.RFC3339)),
+			})
+		})
+		for _, change := range processed {
+			if change.Status == schedule.StatusFailed {
+				logger.Log.Printf("Failed to apply scheduled change %s: %s", change.ID, change.Error)
+			}
+			tenantNamed(change.Tenant).Audit.LogScheduledChange("SYSTEM", change.Status, change.ID, change.Product, change.Environment, change.Keys(), change.EffectiveAt.Format(time.RFC3339), change.ScheduledBy)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"simpleConfigServer/internal/requestid"
+	"simpleConfigServer/internal/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// IsProbe reports whether a request is a health or readiness probe or a
+// metrics scrape. These are neither logged nor traced.
+func IsProbe(c *fiber.Ctx) bool {
+	return c.Path() == "/healthz" || c.Path() == "/readyz" || c.Path() == "/metrics"
+}
+
+// Trace is middleware that starts a span for every request, continuing the
+// trace of the caller when the request carries W3C traceparent and
+// tracestate headers. Handlers start their spans below c.UserContext().
+func Trace(c *fiber.Ctx) error {
+	if IsProbe(c) {
+		return c.Next()
+	}
+
+	// Strings of the request are backed by buffers fiber reuses
+	method := utils.CopyString(c.Method())
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()))
+	ctx, span := tracing.StartServer(ctx, method,
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", utils.CopyString(c.Path())),
+		attribute.String("client.address", utils.CopyString(c.IP())),
+		attribute.String("request.id", requestid.FromContext(ctx)))
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	// Errors are handled here so that the status they lead to is recorded
+	if err := c.Next(); err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	status := c.Response().StatusCode()
+	span.SetName(method + " " + c.Route().Path)
+	span.SetAttributes(
+		attribute.String("http.route", c.Route().Path),
+		attribute.Int("http.response.status_code", status))
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, utils.StatusMessage(status))
+	}
+	return nil
+}
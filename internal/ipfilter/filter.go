@@ -2,37 +2,145 @@ package ipfilter
 
 import (
 	"bufio"
+	"context"
 	"os"
 	"simpleConfigServer/internal/audit"
 	"simpleConfigServer/internal/logger"
+	"sort"
 	"strings"
 	"sync"
 )
 
-var (
-	allowedIPs = make(map[string]bool)
+// Filter is an IP allowlist loaded from a file.
+type Filter struct {
+	file       string
+	allowedIPs map[string]bool
+	audit      *audit.Stream
+	loaded     bool
+	watching   bool
+	err        string
 	mu         sync.RWMutex
-)
+}
+
+// Status describes whether a filter's allowlist file was loaded and is
+// watched for changes. Error is the last failed read, cleared by the next
+// successful one.
+type Status struct {
+	File     string `json:"file"`
+	Loaded   bool   `json:"loaded"`
+	Watching bool   `json:"watching"`
+	Error    string `json:"error,omitempty"`
+}
+
+var defaultFilter = &Filter{allowedIPs: make(map[string]bool), audit: audit.Default()}
+
+// NewFilter returns a filter for the allowlist in file that records its
+// decisions in stream. It is empty, and therefore allows all IPs, until Load
+// is called.
+func NewFilter(file string, stream *audit.Stream) *Filter {
+	return &Filter{file: file, allowedIPs: make(map[string]bool), audit: stream}
+}
+
+// Default returns the filter used by LoadAllowedIPs and IsIPAllowed.
+func Default() *Filter {
+	return defaultFilter
+}
 
 func LoadAllowedIPs(AllowedIPsFile string) {
-	file, err := os.Open(AllowedIPsFile)
+	defaultFilter.mu.Lock()
+	defaultFilter.file = AllowedIPsFile
+	defaultFilter.mu.Unlock()
+	defaultFilter.Load()
+}
+
+func IsIPAllowed(ip string) bool {
+	return defaultFilter.IsAllowed(context.Background(), ip)
+}
+
+// File returns the allowlist file of the filter.
+func (f *Filter) File() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.file
+}
+
+// Status returns the state of the filter's allowlist file.
+func (f *Filter) Status() Status {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return Status{File: f.file, Loaded: f.loaded, Watching: f.watching, Error: f.err}
+}
+
+// Load reads the allowlist file, replacing the previous list. The process
+// exits when the file cannot be read.
+func (f *Filter) Load() {
+	if err := f.Reload(); err != nil {
+		logger.Log.Fatalf("Failed to load allowed IPs file: %v", err)
+	}
+}
+
+// Reload reads the allowlist file, replacing the previous list. When the
+// file cannot be read the previous list is kept and the error is reported,
+// and shown in Status until the next successful read.
+func (f *Filter) Reload() error {
+	AllowedIPsFile := f.File()
+
+	newIpMap, err := readAllowlist(AllowedIPsFile)
 	if err != nil {
-		logger.Log.Fatalf("Failed to open allowed IPs file: %v", err)
-		audit.LogSystem("IP_FILTER_LOAD", "FAILED", map[string]interface{}{
+		logger.Log.Printf("Failed to read allowed IPs file %s: %v", AllowedIPsFile, err)
+		f.audit.LogSystem("IP_FILTER_LOAD", "FAILED", map[string]interface{}{
 			"file":  AllowedIPsFile,
 			"error": err.Error(),
 		})
+		f.mu.Lock()
+		f.err = err.Error()
+		f.mu.Unlock()
+		return err
 	}
-	defer file.Close()
 
-	newIpMap := make(map[string]bool)
 	oldIpMap := make(map[string]bool)
-	mu.RLock()
-	for ip := range allowedIPs {
+	f.mu.Lock()
+	for ip := range f.allowedIPs {
 		oldIpMap[ip] = true
 	}
-	mu.RUnlock()
+	f.allowedIPs = newIpMap
+	f.loaded = true
+	f.err = ""
+	f.mu.Unlock()
+
+	// Log IP changes
+	for ip := range newIpMap {
+		if _, exists := oldIpMap[ip]; !exists {
+			f.audit.LogSystem("IP_FILTER_CHANGE", "ADDED", map[string]interface{}{
+				"ip": ip,
+			})
+		}
+	}
 
+	for ip := range oldIpMap {
+		if _, exists := newIpMap[ip]; !exists {
+			f.audit.LogSystem("IP_FILTER_CHANGE", "REMOVED", map[string]interface{}{
+				"ip": ip,
+			})
+		}
+	}
+
+	f.audit.LogSystem("IP_FILTER_LOAD", "SUCCESS", map[string]interface{}{
+		"file": AllowedIPsFile,
+	})
+	return nil
+}
+
+// readAllowlist parses an allowlist file: one IP per line, with blank lines
+// and # comments ignored.
+func readAllowlist(AllowedIPsFile string) (map[string]bool, error) {
+	file, err := os.Open(AllowedIPsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	newIpMap := make(map[string]bool)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -51,61 +159,47 @@ func LoadAllowedIPs(AllowedIPsFile string) {
 			newIpMap[line] = true
 		}
 	}
-
 	if err := scanner.Err(); err != nil {
-		logger.Log.Fatalf("Error reading allowed IPs file: %v", err)
-		audit.LogSystem("IP_FILTER_LOAD", "FAILED", map[string]interface{}{
-			"file":  AllowedIPsFile,
-			"error": err.Error(),
-		})
-	}
-
-	mu.Lock()
-	allowedIPs = newIpMap
-	mu.Unlock()
-
-	// Log IP changes
-	for ip := range newIpMap {
-		if _, exists := oldIpMap[ip]; !exists {
-			audit.LogSystem("IP_FILTER_CHANGE", "ADDED", map[string]interface{}{
-				"ip": ip,
-			})
-		}
+		return nil, err
 	}
+	return newIpMap, nil
+}
 
-	for ip := range oldIpMap {
-		if _, exists := newIpMap[ip]; !exists {
-			audit.LogSystem("IP_FILTER_CHANGE", "REMOVED", map[string]interface{}{
-				"ip": ip,
-			})
-		}
+// IPs returns the allowlist in sorted order.
+func (f *Filter) IPs() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ips := make([]string, 0, len(f.allowedIPs))
+	for ip := range f.allowedIPs {
+		ips = append(ips, ip)
 	}
-
-	audit.LogSystem("IP_FILTER_LOAD", "SUCCESS", map[string]interface{}{
-		"file": AllowedIPsFile,
-	})
+	sort.Strings(ips)
+	return ips
 }
 
-func IsIPAllowed(ip string) bool {
-	mu.RLock()
-	defer mu.RUnlock()
+// IsAllowed reports whether ip is on the allowlist. An empty list allows
+// all IPs. The decision is logged and audited with the request ID in ctx.
+func (f *Filter) IsAllowed(ctx context.Context, ip string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	if len(allowedIPs) == 0 {
-		logger.Log.Println("Allowed IPs list is empty, allowing all IPs")
-		audit.LogSecurity(ip, "ALLOWED", "IP_FILTER", map[string]interface{}{
+	stream := f.audit.WithContext(ctx)
+	if len(f.allowedIPs) == 0 {
+		logger.For(ctx).Println("Allowed IPs list is empty, allowing all IPs")
+		stream.LogSecurity(ip, "ALLOWED", "IP_FILTER", map[string]interface{}{
 			"reason": "IP list empty",
 		})
 		return true
 	}
 
-	isAllowed, exists := allowedIPs[ip]
+	isAllowed, exists := f.allowedIPs[ip]
 	if !exists {
-		logger.Log.Printf("IP %s is not allowed", ip)
-		audit.LogSecurity(ip, "DENIED", "IP_FILTER", map[string]interface{}{
+		logger.For(ctx).Printf("IP %s is not allowed", ip)
+		stream.LogSecurity(ip, "DENIED", "IP_FILTER", map[string]interface{}{
 			"reason": "IP not in allowed list",
 		})
 	} else {
-		audit.LogSecurity(ip, "ALLOWED", "IP_FILTER", map[string]interface{}{
+		stream.LogSecurity(ip, "ALLOWED", "IP_FILTER", map[string]interface{}{
 			"reason": "IP in allowed list",
 		})
 	}
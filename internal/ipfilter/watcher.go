@@ -1,22 +1,33 @@
 package ipfilter
 
 import (
-	"simpleConfigServer/internal/audit"
 	"simpleConfigServer/internal/logger"
 
 	"github.com/fsnotify/fsnotify"
 )
 
 func WatchAllowedIPsFile(AllowedIPsFile string) {
+	defaultFilter.mu.Lock()
+	defaultFilter.file = AllowedIPsFile
+	defaultFilter.mu.Unlock()
+	defaultFilter.Watch()
+}
+
+// Watch reloads the filter whenever its allowlist file changes. It blocks
+// for as long as the file is watched. Changes that cannot be read are
+// reported and the previous list is kept.
+func (f *Filter) Watch() {
+	AllowedIPsFile := f.File()
+
 	logger.Log.Printf("Watching allowed IPs file: %s", AllowedIPsFile)
-	audit.LogSystem("IP_FILTER_WATCH", "STARTED", map[string]interface{}{
+	f.audit.LogSystem("IP_FILTER_WATCH", "STARTED", map[string]interface{}{
 		"file": AllowedIPsFile,
 	})
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		logger.Log.Fatal(err)
-		audit.LogSystem("IP_FILTER_WATCH", "FAILED", map[string]interface{}{
+		f.audit.LogSystem("IP_FILTER_WATCH", "FAILED", map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
@@ -25,11 +36,14 @@ func WatchAllowedIPsFile(AllowedIPsFile string) {
 	err = watcher.Add(AllowedIPsFile)
 	if err != nil {
 		logger.Log.Fatal("Error watching allowed IPs file: ", err)
-		audit.LogSystem("IP_FILTER_WATCH", "FAILED", map[string]interface{}{
+		f.audit.LogSystem("IP_FILTER_WATCH", "FAILED", map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
 
+	f.setWatching(true)
+	defer f.setWatching(false)
+
 	for {
 		select {
 		case event, ok := <-watcher.Events:
@@ -38,20 +52,28 @@ func WatchAllowedIPsFile(AllowedIPsFile string) {
 			}
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				logger.Log.Printf("Allowed IPs file changed: %s", event.Name)
-				audit.LogSystem("IP_FILTER_CHANGE", "DETECTED", map[string]interface{}{
+				f.audit.LogSystem("IP_FILTER_CHANGE", "DETECTED", map[string]interface{}{
 					"file": event.Name,
 					"op":   event.Op.String(),
 				})
-				LoadAllowedIPs(AllowedIPsFile)
+				// A file that cannot be read keeps the previous list
+				// rather than taking the server down
+				f.Reload()
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
 			}
 			logger.Log.Printf("Error watching allowed IPs file: %v", err)
-			audit.LogSystem("IP_FILTER_WATCH", "ERROR", map[string]interface{}{
+			f.audit.LogSystem("IP_FILTER_WATCH", "ERROR", map[string]interface{}{
 				"error": err.Error(),
 			})
 		}
 	}
 }
+
+func (f *Filter) setWatching(watching bool) {
+	f.mu.Lock()
+	f.watching = watching
+	f.mu.Unlock()
+}
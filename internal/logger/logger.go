@@ -1,9 +1,10 @@
 package logger
 
 import (
-	"flag"
+	"context"
 	"log"
 	"os"
+	"simpleConfigServer/internal/requestid"
 )
 
 var (
@@ -13,7 +14,6 @@ var (
 func init() {
 	var logpath = "application.log"
 
-	flag.Parse()
 	var file, err1 = os.Create(logpath)
 
 	if err1 != nil {
@@ -22,3 +22,13 @@ func init() {
 	Log = log.New(file, "", log.LstdFlags|log.Lshortfile)
 	Log.Println("LogFile : " + logpath)
 }
+
+// For returns a logger writing to Log that prefixes every message with the
+// request ID in ctx, if any.
+func For(ctx context.Context) *log.Logger {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return Log
+	}
+	return log.New(Log.Writer(), "request_id="+id+" ", Log.Flags()|log.Lmsgprefix)
+}
@@ -0,0 +1,136 @@
+// Package metrics exports the server's Prometheus metrics. Other packages
+// record events through the functions below rather than the collectors.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "config_server"
+
+// Registry holds every metric of the server, along with the Go runtime and
+// process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer HTTP requests by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected tokens by tenant and reason.",
+	}, []string{"tenant", "reason"})
+
+	ipDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ip_filter_denials_total",
+		Help:      "Requests from IPs missing from the allowlist, by tenant.",
+	}, []string{"tenant"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by tenant.",
+	}, []string{"tenant"})
+
+	reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Config documents loaded, by product.",
+	}, []string{"product"})
+
+	loadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_load_failures_total",
+		Help:      "Config documents or flags that failed to load, by product.",
+	}, []string{"product"})
+
+	products = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "products_loaded",
+		Help:      "Products with at least one loaded environment.",
+	})
+
+	keys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "keys_loaded",
+		Help:      "Config keys loaded across the environments of a product.",
+	}, []string{"product"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration, authFailures, ipDenials, rateLimited,
+		reloads, loadFailures, products, keys,
+	)
+}
+
+// ObserveRequest records an answered HTTP request. Route is the pattern the
+// request matched, e.g. "/v1/:product/:env/:key".
+func ObserveRequest(route string, method string, status int, elapsed time.Duration) {
+	requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// AuthFailed records a rejected token. Reason is the error code reported to
+// the client, e.g. "token_expired".
+func AuthFailed(tenant string, reason string) {
+	authFailures.WithLabelValues(tenant, reason).Inc()
+}
+
+// IPDenied records a request from an IP missing from the allowlist.
+func IPDenied(tenant string) {
+	ipDenials.WithLabelValues(tenant).Inc()
+}
+
+// RateLimited records a request rejected by the rate limiter.
+func RateLimited(tenant string) {
+	rateLimited.WithLabelValues(tenant).Inc()
+}
+
+// ConfigLoaded records a config document that was loaded.
+func ConfigLoaded(product string) {
+	reloads.WithLabelValues(product).Inc()
+}
+
+// ConfigLoadFailed records a config document or flag that failed to load.
+func ConfigLoadFailed(product string) {
+	loadFailures.WithLabelValues(product).Inc()
+}
+
+// SetLoaded replaces the loaded products and key counts.
+func SetLoaded(keysByProduct map[string]int) {
+	products.Set(float64(len(keysByProduct)))
+	keys.Reset()
+	for product, count := range keysByProduct {
+		keys.WithLabelValues(product).Set(float64(count))
+	}
+}
+
+// TrackLimiters exports the number of client IPs a tenant's rate limiter
+// keeps a limiter for, as reported by count at scrape time.
+func TrackLimiters(tenant string, count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "rate_limiters_tracked",
+		Help:        "Client IPs with a rate limiter, by tenant.",
+		ConstLabels: prometheus.Labels{"tenant": tenant},
+	}, func() float64 { return float64(count()) }))
+}
@@ -6,18 +6,50 @@ import (
 	"golang.org/x/time/rate"
 )
 
-var (
-	rateLimiters = make(map[string]*rate.Limiter)
+// Limiters hands out one token bucket per client IP.
+type Limiters struct {
+	rateLimiters map[string]*rate.Limiter
+	limit        rate.Limit
+	burst        int
 	mu           sync.RWMutex
-)
+}
+
+var defaultLimiters = NewLimiters(5, 5) // Allow 5 requests per second per IP
+
+// NewLimiters allows each IP limit requests per second with bursts of up to
+// burst requests.
+func NewLimiters(limit float64, burst int) *Limiters {
+	return &Limiters{
+		rateLimiters: make(map[string]*rate.Limiter),
+		limit:        rate.Limit(limit),
+		burst:        burst,
+	}
+}
 
 func GetRateLimiter(ip string) *rate.Limiter {
-	mu.Lock()
-	defer mu.Unlock()
-	if limiter, exists := rateLimiters[ip]; exists {
+	return defaultLimiters.Get(ip)
+}
+
+// Get returns the limiter of ip, creating it on first use.
+func (l *Limiters) Get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter, exists := l.rateLimiters[ip]; exists {
 		return limiter
 	}
-	limiter := rate.NewLimiter(5, 5) // Allow 5 requests per second per IP
-	rateLimiters[ip] = limiter
+	limiter := rate.NewLimiter(l.limit, l.burst)
+	l.rateLimiters[ip] = limiter
 	return limiter
 }
+
+// Len returns the number of IPs a limiter was created for.
+func (l *Limiters) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.rateLimiters)
+}
+
+// Default returns the limiters used by GetRateLimiter.
+func Default() *Limiters {
+	return defaultLimiters
+}
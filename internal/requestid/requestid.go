@@ -0,0 +1,39 @@
+// Package requestid carries the ID of the request being handled in a
+// context, so that the audit events and log lines it leads to can be
+// linked.
+package requestid
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header, and gRPC metadata key, holding request IDs.
+const Header = "X-Request-ID"
+
+// pattern restricts IDs sent by clients, as they end up in logs.
+var pattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey struct{}
+
+// Accept returns the ID a client sent when it is well formed, and a new
+// UUID otherwise.
+func Accept(sent string) string {
+	if pattern.MatchString(sent) {
+		return sent
+	}
+	return uuid.NewString()
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "" outside of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,190 @@
+package schedule
+
+import (
+	"errors"
+	"time"
+
+	"simpleConfigServer/internal/changestore"
+)
+
+const (
+	StatusPendingApproval = "PENDING_APPROVAL"
+	StatusScheduled       = "SCHEDULED"
+	StatusApplied         = "APPLIED"
+	StatusFailed          = "FAILED"
+	StatusCancelled       = "CANCELLED"
+)
+
+var (
+	ErrNotFound     = errors.New("scheduled change not found")
+	ErrNotScheduled = errors.New("change is no longer scheduled")
+	ErrNotPending   = errors.New("change is not awaiting approval")
+	ErrSelfApproval = errors.New("scheduled changes must be approved by someone other than the user who scheduled them")
+)
+
+// Change is a change to one product environment, keys to set and keys to
+// remove, that takes effect at EffectiveAt. A change that NeedsApproval is
+// only applied once another principal approved it, even when EffectiveAt
+// has passed by then.
+type Change struct {
+	ID            string            `json:"id"`
+	Tenant        string            `json:"tenant,omitempty"`
+	Product       string            `json:"product"`
+	Environment   string            `json:"environment"`
+	Set           map[string]string `json:"set,omitempty"`
+	Remove        []string          `json:"remove,omitempty"`
+	Comment       string            `json:"comment,omitempty"`
+	EffectiveAt   time.Time         `json:"effective_at"`
+	Status        string            `json:"status"`
+	ScheduledBy   string            `json:"scheduled_by"`
+	ScheduledAt   time.Time         `json:"scheduled_at"`
+	NeedsApproval bool              `json:"needs_approval,omitempty"`
+	ApprovedBy    string            `json:"approved_by,omitempty"`
+	ApprovedAt    *time.Time        `json:"approved_at,omitempty"`
+	AppliedAt     *time.Time        `json:"applied_at,omitempty"`
+	CancelledBy   string            `json:"cancelled_by,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// Keys lists the keys the change sets or removes, in name order.
+func (c *Change) Keys() []string {
+	return changestore.Keys(c.Set, c.Remove)
+}
+
+// Store keeps scheduled changes in a JSON file, see changestore.
+type Store struct {
+	changes *changestore.Store[Change]
+}
+
+var defaultStore = &Store{changes: changestore.New(changeID, scheduledBefore)}
+
+func changeID(c *Change) string {
+	return c.ID
+}
+
+func scheduledBefore(a, b *Change) bool {
+	return a.ScheduledAt.Before(b.ScheduledAt)
+}
+
+func effectiveBefore(a, b *Change) bool {
+	return a.EffectiveAt.Before(b.EffectiveAt)
+}
+
+// Default returns the store used by the handlers. It keeps the schedule in
+// memory only until Load is called.
+func Default() *Store {
+	return defaultStore
+}
+
+// Load makes the default store persist to path, reading the schedule saved
+// there.
+func Load(path string) error {
+	store, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defaultStore = store
+	return nil
+}
+
+// Open reads the schedule saved in path. A missing file is an empty
+// schedule.
+func Open(path string) (*Store, error) {
+	changes, err := changestore.Open(path, changeID, scheduledBefore)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{changes: changes}, nil
+}
+
+// Add schedules a change. Its ID, status and scheduling time are set by the
+// store; a change that needs approval starts out pending approval.
+func (s *Store) Add(c Change) (*Change, error) {
+	id, err := changestore.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	c.ID = id
+	c.Status = StatusScheduled
+	if c.NeedsApproval {
+		c.Status = StatusPendingApproval
+	}
+	c.ScheduledAt = time.Now().UTC()
+	c.EffectiveAt = c.EffectiveAt.UTC()
+	c.ApprovedBy, c.ApprovedAt = "", nil
+	c.AppliedAt, c.CancelledBy, c.Error = nil, "", ""
+	return s.changes.Add(c)
+}
+
+// Get returns a copy of a scheduled change.
+func (s *Store) Get(id string) (*Change, bool) {
+	return s.changes.Get(id)
+}
+
+// List returns copies of the changes of a tenant's product, the earliest
+// effective first. An empty status matches every status.
+func (s *Store) List(tenant string, product string, status string) []*Change {
+	return s.changes.List(func(c *Change) bool {
+		return c.Tenant == tenant && c.Product == product && (status == "" || c.Status == status)
+	}, effectiveBefore)
+}
+
+// Approve schedules a change pending approval on behalf of approver, who
+// must not be the user who scheduled it. It is applied at EffectiveAt, or
+// on the next check when that has passed.
+func (s *Store) Approve(id string, approver string) (*Change, error) {
+	return s.changes.Update(id, func(c *Change) error {
+		if c == nil {
+			return ErrNotFound
+		}
+		if c.Status != StatusPendingApproval {
+			return ErrNotPending
+		}
+		if approver == "" || approver == c.ScheduledBy {
+			return ErrSelfApproval
+		}
+		now := time.Now().UTC()
+		c.Status = StatusScheduled
+		c.ApprovedBy = approver
+		c.ApprovedAt = &now
+		return nil
+	})
+}
+
+// Cancel withdraws a change that has not taken effect yet.
+func (s *Store) Cancel(id string, userID string) (*Change, error) {
+	return s.changes.Update(id, func(c *Change) error {
+		if c == nil {
+			return ErrNotFound
+		}
+		if c.Status != StatusScheduled && c.Status != StatusPendingApproval {
+			return ErrNotScheduled
+		}
+		c.Status = StatusCancelled
+		c.CancelledBy = userID
+		return nil
+	})
+}
+
+// ApplyDue calls apply for every scheduled change whose time has come, in
+// order of effective time, and records whether it succeeded. Changes
+// pending approval are skipped. It returns copies of the changes it
+// processed.
+func (s *Store) ApplyDue(apply func(c *Change) error) []*Change {
+	now := time.Now().UTC()
+	return s.changes.UpdateAll(effectiveBefore, func(c *Change) bool {
+		if c.Status != StatusScheduled || now.Before(c.EffectiveAt) {
+			return false
+		}
+		appliedAt := time.Now().UTC()
+		c.AppliedAt = &appliedAt
+		if err := apply(c); err != nil {
+			c.Status = StatusFailed
+			c.Error = err.Error()
+		} else {
+			c.Status = StatusApplied
+		}
+		return true
+	})
+}
@@ -0,0 +1,206 @@
+package schedule
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	errApply := errors.New("write failed")
+
+	type step struct {
+		action  string // approve, cancel, due, apply or fail
+		user    string
+		wantErr error
+	}
+	tests := []struct {
+		name          string
+		needsApproval bool
+		steps         []step
+		wantStatus    string
+		applied       int
+	}{
+		{
+			name:       "applied once due",
+			steps:      []step{{action: "apply"}, {action: "due"}, {action: "apply"}, {action: "apply"}},
+			wantStatus: StatusApplied,
+			applied:    1,
+		},
+		{
+			name:       "failed apply",
+			steps:      []step{{action: "due"}, {action: "fail"}, {action: "apply"}},
+			wantStatus: StatusFailed,
+		},
+		{
+			name:          "not applied without approval",
+			needsApproval: true,
+			steps:         []step{{action: "due"}, {action: "apply"}},
+			wantStatus:    StatusPendingApproval,
+		},
+		{
+			name:          "approved by its author",
+			needsApproval: true,
+			steps:         []step{{action: "approve", user: "alice", wantErr: ErrSelfApproval}, {action: "due"}, {action: "apply"}},
+			wantStatus:    StatusPendingApproval,
+		},
+		{
+			name:          "approved anonymously",
+			needsApproval: true,
+			steps:         []step{{action: "approve", user: "", wantErr: ErrSelfApproval}},
+			wantStatus:    StatusPendingApproval,
+		},
+		{
+			name:          "approved by someone else",
+			needsApproval: true,
+			steps:         []step{{action: "approve", user: "bob"}, {action: "apply"}, {action: "due"}, {action: "apply"}},
+			wantStatus:    StatusApplied,
+			applied:       1,
+		},
+		{
+			name:          "approved after it was due",
+			needsApproval: true,
+			steps:         []step{{action: "due"}, {action: "apply"}, {action: "approve", user: "bob"}, {action: "apply"}},
+			wantStatus:    StatusApplied,
+			applied:       1,
+		},
+		{
+			name:          "approved twice",
+			needsApproval: true,
+			steps:         []step{{action: "approve", user: "bob"}, {action: "approve", user: "carol", wantErr: ErrNotPending}},
+			wantStatus:    StatusScheduled,
+		},
+		{
+			name:       "approval not needed",
+			steps:      []step{{action: "approve", user: "bob", wantErr: ErrNotPending}},
+			wantStatus: StatusScheduled,
+		},
+		{
+			name:       "cancelled",
+			steps:      []step{{action: "cancel", user: "bob"}, {action: "due"}, {action: "apply"}},
+			wantStatus: StatusCancelled,
+		},
+		{
+			name:          "cancelled while pending approval",
+			needsApproval: true,
+			steps:         []step{{action: "cancel", user: "alice"}, {action: "approve", user: "bob", wantErr: ErrNotPending}, {action: "due"}, {action: "apply"}},
+			wantStatus:    StatusCancelled,
+		},
+		{
+			name:       "cancelled twice",
+			steps:      []step{{action: "cancel", user: "alice"}, {action: "cancel", user: "alice", wantErr: ErrNotScheduled}},
+			wantStatus: StatusCancelled,
+		},
+		{
+			name:       "cancelled after apply",
+			steps:      []step{{action: "due"}, {action: "apply"}, {action: "cancel", user: "alice", wantErr: ErrNotScheduled}},
+			wantStatus: StatusApplied,
+			applied:    1,
+		},
+		{
+			name:       "cancelled after a failed apply",
+			steps:      []step{{action: "due"}, {action: "fail"}, {action: "cancel", user: "alice", wantErr: ErrNotScheduled}},
+			wantStatus: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scheduled_changes.json")
+			store, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			change, err := store.Add(Change{
+				Product:       "app",
+				Environment:   "production",
+				Set:           map[string]string{"a": "1"},
+				EffectiveAt:   time.Now().Add(time.Hour),
+				ScheduledBy:   "alice",
+				NeedsApproval: tt.needsApproval,
+			})
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			applied := 0
+			for _, s := range tt.steps {
+				err = nil
+				switch s.action {
+				case "approve":
+					_, err = store.Approve(change.ID, s.user)
+				case "cancel":
+					_, err = store.Cancel(change.ID, s.user)
+				case "due":
+					_, err = store.changes.Update(change.ID, func(c *Change) error {
+						c.EffectiveAt = time.Now().UTC().Add(-time.Second)
+						return nil
+					})
+				case "apply":
+					store.ApplyDue(func(*Change) error { applied++; return nil })
+				case "fail":
+					store.ApplyDue(func(*Change) error { return errApply })
+				}
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("%s by %q: error = %v, want %v", s.action, s.user, err, s.wantErr)
+				}
+			}
+
+			if applied != tt.applied {
+				t.Errorf("applied %d times, want %d", applied, tt.applied)
+			}
+			// The outcome survives a restart
+			reopened, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			got, ok := reopened.Get(change.ID)
+			if !ok {
+				t.Fatalf("change %s not saved", change.ID)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status %s, want %s", got.Status, tt.wantStatus)
+			}
+			if got.Status == StatusFailed && got.Error != errApply.Error() {
+				t.Errorf("error %q, want %q", got.Error, errApply)
+			}
+		})
+	}
+}
+
+func TestApplyDueOrder(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "scheduled_changes.json"))
+	now := time.Now()
+	for _, offset := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second, time.Hour} {
+		if _, err := store.Add(Change{Product: "app", Comment: offset.String(), EffectiveAt: now.Add(offset), ScheduledBy: "alice"}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	store.changes.UpdateAll(effectiveBefore, func(c *Change) bool {
+		c.EffectiveAt = c.EffectiveAt.Add(-5 * time.Second)
+		return true
+	})
+
+	var order []string
+	processed := store.ApplyDue(func(c *Change) error {
+		order = append(order, c.Comment)
+		return nil
+	})
+	if len(processed) != 3 || len(order) != 3 || order[0] != "1s" || order[1] != "2s" || order[2] != "3s" {
+		t.Errorf("applied %v, want [1s 2s 3s]", order)
+	}
+	if scheduled := store.List("", "app", StatusScheduled); len(scheduled) != 1 || scheduled[0].Comment != "1h0m0s" {
+		t.Errorf("still scheduled: %v, want the 1h change", scheduled)
+	}
+}
+
+func TestUnknownChange(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "scheduled_changes.json"))
+	if _, err := store.Approve("missing", "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Approve() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := store.Cancel("missing", "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel() error = %v, want %v", err, ErrNotFound)
+	}
+}
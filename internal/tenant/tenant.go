@@ -0,0 +1,256 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"simpleConfigServer/internal/audit"
+	"simpleConfigServer/internal/auth"
+	"simpleConfigServer/internal/ipfilter"
+	"simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/metrics"
+	"simpleConfigServer/internal/rate_limiter"
+	"simpleConfigServer/internal/tracing"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v2"
+)
+
+// Tenant is a namespace with its own config tree, {tenant}/{product}/{env}.yml,
+// and its own allowlist, token validation, rate limits and audit log.
+type Tenant struct {
+	Name    string
+	Filter  *ipfilter.Filter
+	Auth    *auth.Validator
+	Limiter *rate_limiter.Limiters
+	Audit   *audit.Stream
+}
+
+// Settings is the configuration of one tenant in the tenants file. Relative
+// paths are resolved against the directory of the tenants file.
+type Settings struct {
+	AllowedIPsFile string  `yaml:"allowed_ips_file"`
+	JWTSecret      string  `yaml:"jwt_secret"`
+	JWTSecretEnv   string  `yaml:"jwt_secret_env"`
+	JWTIssuer      string  `yaml:"jwt_issuer"`
+	RateLimit      float64 `yaml:"rate_limit"`
+	RateBurst      int     `yaml:"rate_burst"`
+	AuditDir       string  `yaml:"audit_dir"`
+}
+
+type tenantsFile struct {
+	Tenants map[string]Settings `yaml:"tenants"`
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tenants is nil unless a tenants file was loaded, in which case every
+// request must name its tenant.
+var tenants map[string]*Tenant
+
+var defaultTenant = &Tenant{
+	Filter:  ipfilter.Default(),
+	Auth:    auth.Default(),
+	Limiter: rate_limiter.Default(),
+	Audit:   audit.Default(),
+}
+
+// Load reads the tenants file and enables multi-tenant mode.
+func Load(file string) error {
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var parsed tenantsFile
+	if err := yaml.Unmarshal(bytes, &parsed); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	if len(parsed.Tenants) == 0 {
+		return fmt.Errorf("%s declares no tenants", file)
+	}
+
+	baseDir := filepath.Dir(file)
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+
+	loaded := make(map[string]*Tenant, len(parsed.Tenants))
+	for name, settings := range parsed.Tenants {
+		if !namePattern.MatchString(name) {
+			return fmt.Errorf("invalid tenant name %q", name)
+		}
+
+		secret := settings.JWTSecret
+		if settings.JWTSecretEnv != "" {
+			secret = os.Getenv(settings.JWTSecretEnv)
+		}
+		if secret == "" {
+			return fmt.Errorf("tenant %s: no JWT secret configured", name)
+		}
+
+		auditDir := resolve(settings.AuditDir)
+		if auditDir == "" {
+			auditDir = filepath.Join("audit_logs", name)
+		}
+		stream, err := audit.NewStream(auditDir)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", name, err)
+		}
+
+		limit := settings.RateLimit
+		if limit <= 0 {
+			limit = 5
+		}
+		burst := settings.RateBurst
+		if burst <= 0 {
+			burst = int(limit)
+		}
+
+		// Tenants without an allowlist of their own share the server wide one
+		filter := ipfilter.Default()
+		if settings.AllowedIPsFile != "" {
+			filter = ipfilter.NewFilter(resolve(settings.AllowedIPsFile), stream)
+			filter.Load()
+		}
+
+		t := &Tenant{
+			Name:    name,
+			Filter:  filter,
+			Auth:    &auth.Validator{Secret: []byte(secret), Issuer: settings.JWTIssuer},
+			Limiter: rate_limiter.NewLimiters(limit, burst),
+			Audit:   stream,
+		}
+		loaded[name] = t
+
+		logger.Log.Printf("Loaded tenant %s", name)
+		audit.LogSystem("TENANT_LOAD", "SUCCESS", map[string]interface{}{
+			"tenant":    name,
+			"audit_dir": auditDir,
+		})
+	}
+
+	tenants = loaded
+	return nil
+}
+
+// Enabled reports whether the server runs in multi-tenant mode.
+func Enabled() bool {
+	return tenants != nil
+}
+
+// Get returns a tenant by name.
+func Get(name string) (*Tenant, bool) {
+	t, ok := tenants[name]
+	return t, ok
+}
+
+// Default returns the tenant used when multi-tenant mode is off. It has no
+// name and uses the server wide allowlist, JWT secret, limits and audit log.
+func Default() *Tenant {
+	return defaultTenant
+}
+
+// All returns the loaded tenants in name order.
+func All() []*Tenant {
+	all := make([]*Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Errors returned by Authorize besides those of auth.Validator.Check
+var (
+	ErrIPNotAllowed = errors.New("IP not allowed")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+)
+
+// Authorize runs the checks shared by the HTTP and gRPC APIs: IP filter,
+// rate limiter and token validation, in that order, each in a span of its
+// own below ctx. Every outcome is audited to the tenant's stream with the
+// request ID in ctx.
+func (t *Tenant) Authorize(ctx context.Context, ip string, token string) (*auth.Claims, error) {
+	stream := t.Audit.WithContext(ctx)
+
+	_, span := tracing.Start(ctx, "ipfilter.check", attribute.String("client.address", ip))
+	allowed := t.Filter.IsAllowed(ctx, ip)
+	if !allowed {
+		stream.LogSecurity(ip, "DENIED", "IP_FILTER", map[string]interface{}{
+			"reason": "IP not in allowed list",
+		})
+		metrics.IPDenied(t.Name)
+		tracing.Fail(span, ErrIPNotAllowed)
+	}
+	span.End()
+	if !allowed {
+		return nil, ErrIPNotAllowed
+	}
+
+	_, span = tracing.Start(ctx, "ratelimit.check")
+	allowed = t.Limiter.Get(ip).Allow()
+	if !allowed {
+		stream.LogSecurity(ip, "DENIED", "RATE_LIMIT", map[string]interface{}{
+			"reason": "Rate limit exceeded",
+		})
+		metrics.RateLimited(t.Name)
+		tracing.Fail(span, ErrRateLimited)
+	}
+	span.End()
+	if !allowed {
+		return nil, ErrRateLimited
+	}
+
+	_, span = tracing.Start(ctx, "auth.validate")
+	defer span.End()
+	claims, err := t.Auth.Check(token)
+	if err != nil {
+		stream.LogAuth(ip, "FAILED", "")
+		reason := "token_invalid"
+		switch err {
+		case auth.ErrTokenMissing:
+			reason = "token_missing"
+		case auth.ErrTokenExpired:
+			reason = "token_expired"
+		}
+		metrics.AuthFailed(t.Name, reason)
+		tracing.Fail(span, err)
+		return nil, err
+	}
+	stream.LogAuth(ip, "SUCCESS", claims.UserID)
+	span.SetAttributes(attribute.String("enduser.id", claims.UserID))
+	return claims, nil
+}
+
+// Product returns the name a product of this tenant is stored under in the
+// config store.
+func (t *Tenant) Product(product string) string {
+	if t.Name == "" {
+		return product
+	}
+	return t.Name + "/" + product
+}
+
+// ProductName returns the name a product stored in the config store has for
+// this tenant, or false when the product belongs to another tenant.
+func (t *Tenant) ProductName(stored string) (string, bool) {
+	if t.Name == "" {
+		return stored, true
+	}
+	return strings.CutPrefix(stored, t.Name+"/")
+}
+
+// HasOwnAllowlist reports whether the tenant has an allowlist file of its
+// own rather than sharing the server wide allowlist.
+func (t *Tenant) HasOwnAllowlist() bool {
+	return t.Filter != ipfilter.Default()
+}
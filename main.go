@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"simpleConfigServer/internal/approval"
 	"simpleConfigServer/internal/audit"
 	"simpleConfigServer/internal/config"
+	"simpleConfigServer/internal/grpcserver"
 	"simpleConfigServer/internal/handler"
 	"simpleConfigServer/internal/ipfilter"
 	applogger "simpleConfigServer/internal/logger"
+	"simpleConfigServer/internal/metrics"
 	"simpleConfigServer/internal/scaffolding"
+	"simpleConfigServer/internal/schedule"
+	"simpleConfigServer/internal/tenant"
+	"simpleConfigServer/internal/tracing"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -17,6 +28,29 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
+var (
+	configDirFlag      = flag.String("config-dir", "", "Directory containing configuration files")
+	allowedIPsFileFlag = flag.String("allowed-ips", "", "File containing allowed IP addresses")
+	watchModeFlag      = flag.String("watch-mode", "", "Config watcher mode: auto, fsnotify or poll")
+	pollIntervalFlag   = flag.Duration("poll-interval", 0, "Interval between config directory scans in poll mode")
+	sourceFlag         = flag.String("source", "", "Config storage backend: file, bolt or git")
+	boltPathFlag       = flag.String("bolt-path", "", "Database file used by the bolt storage backend")
+	gitRepoFlag        = flag.String("git-repo", "", "Git repository read by the git storage backend")
+	gitBranchFlag      = flag.String("git-branch", "", "Branch read by the git storage backend")
+	gitPathFlag        = flag.String("git-path", "", "Directory inside the git repository holding config files")
+	gitAutoCommitFlag  = flag.Bool("git-auto-commit", false, "Commit every config write to the config directory's git repository")
+	tenantsFileFlag    = flag.String("tenants", "", "File declaring tenants; enables multi-tenant mode")
+	changeRequestsFlag = flag.String("change-requests", "", "File pending change requests are saved to")
+	changeTTLFlag      = flag.Duration("change-request-ttl", 0, "Time after which unreviewed change requests expire")
+	schedulesFlag      = flag.String("schedules", "", "File scheduled config changes are saved to")
+	grpcPortFlag       = flag.String("grpc-port", "", "Port the gRPC API listens on; disabled when empty")
+	metricsTokenFlag   = flag.String("metrics-token", "", "Bearer token required to scrape /metrics; open when empty")
+	traceExporterFlag  = flag.String("trace-exporter", "", "Trace exporter: none, stdout, file or otlp")
+	traceFileFlag      = flag.String("trace-file", "", "File spans are written to by the file trace exporter")
+	adminTokenFlag     = flag.String("admin-token", "", "Bearer token required by the /admin routes; disabled when empty")
+	envPlaceholderFlag = flag.String("env-placeholders", "", "Comma separated environment variables, or prefixes ending in *, that ${env:NAME} may read")
+)
+
 // Get the working directory
 func getWorkingDir() string {
 	execDir, err := os.Getwd()
@@ -29,11 +63,8 @@ func getWorkingDir() string {
 // Get configuration directory
 func getConfigDir() string {
 	// Check CLI flag first
-	configDir := flag.String("config-dir", "", "Directory containing configuration files")
-	flag.Parse()
-
-	if *configDir != "" {
-		return *configDir
+	if *configDirFlag != "" {
+		return *configDirFlag
 	}
 
 	// Then check environment variable
@@ -48,11 +79,8 @@ func getConfigDir() string {
 // Get allowed IPs file path
 func getAllowedIPsFile() string {
 	// Check CLI flag first
-	allowedIPsFile := flag.String("allowed-ips", "", "File containing allowed IP addresses")
-	flag.Parse()
-
-	if *allowedIPsFile != "" {
-		return *allowedIPsFile
+	if *allowedIPsFileFlag != "" {
+		return *allowedIPsFileFlag
 	}
 
 	// Then check environment variable
@@ -64,6 +92,128 @@ func getAllowedIPsFile() string {
 	return filepath.Join(getWorkingDir(), "allowed_ips.txt")
 }
 
+// Get config watcher mode
+func getWatchMode() string {
+	mode := *watchModeFlag
+	if mode == "" {
+		mode = os.Getenv("WATCH_MODE")
+	}
+
+	switch mode {
+	case "":
+		return config.WatchModeAuto
+	case config.WatchModeAuto, config.WatchModeFsnotify, config.WatchModePoll:
+		return mode
+	default:
+		applogger.Log.Fatalf("Unknown watch mode %q", mode)
+		return ""
+	}
+}
+
+// Get polling interval for the config watcher
+func getPollInterval() time.Duration {
+	if *pollIntervalFlag > 0 {
+		return *pollIntervalFlag
+	}
+
+	if value := os.Getenv("POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			applogger.Log.Fatalf("Invalid POLL_INTERVAL %q: %v", value, err)
+		}
+		return interval
+	}
+
+	return config.DefaultPollInterval
+}
+
+// Get how long change requests stay pending
+func getChangeRequestTTL() time.Duration {
+	if *changeTTLFlag > 0 {
+		return *changeTTLFlag
+	}
+
+	if value := os.Getenv("CHANGE_REQUEST_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			applogger.Log.Fatalf("Invalid CHANGE_REQUEST_TTL %q: %v", value, err)
+		}
+		return ttl
+	}
+
+	return approval.DefaultTTL
+}
+
+// Get a setting from its CLI flag, then its environment variable, then the default
+func getSetting(flagValue string, envName string, defaultValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if value := os.Getenv(envName); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Allow ${env:NAME} placeholders to read the configured environment variables
+func allowEnvPlaceholders() {
+	if names := getSetting(*envPlaceholderFlag, "ENV_PLACEHOLDERS", ""); names != "" {
+		config.AllowEnvPlaceholders(strings.Split(names, ","))
+	}
+}
+
+// Get config storage backend
+func getSource(configDir string) config.Source {
+	kind := getSetting(*sourceFlag, "CONFIG_SOURCE", "file")
+
+	fileSource := config.NewFileSource(configDir, getWatchMode(), getPollInterval())
+
+	switch kind {
+	case "file":
+		if *gitAutoCommitFlag || os.Getenv("GIT_AUTO_COMMIT") == "true" {
+			if err := fileSource.EnableAutoCommit(); err != nil {
+				applogger.Log.Fatalf("Cannot auto-commit config writes: %v", err)
+			}
+			applogger.Log.Printf("Committing config writes to the git repository of %s", configDir)
+		}
+		return fileSource
+	case "bolt":
+		path := getSetting(*boltPathFlag, "BOLT_PATH", filepath.Join(getWorkingDir(), "configs.db"))
+
+		boltSource, err := config.NewBoltSource(path)
+		if err != nil {
+			applogger.Log.Fatalf("Failed to open config database %s: %v", path, err)
+		}
+
+		// Seed an empty database from the config directory
+		ids, err := boltSource.List()
+		if err != nil {
+			applogger.Log.Fatalf("Failed to read config database %s: %v", path, err)
+		}
+		if len(ids) == 0 {
+			imported, err := boltSource.Import(fileSource)
+			if err != nil {
+				applogger.Log.Fatalf("Failed to import %s into %s: %v", configDir, path, err)
+			}
+			applogger.Log.Printf("Imported %d config files from %s into %s", imported, configDir, path)
+		}
+		return boltSource
+	case "git":
+		repo := getSetting(*gitRepoFlag, "GIT_REPO", configDir)
+		branch := getSetting(*gitBranchFlag, "GIT_BRANCH", "main")
+		dir := getSetting(*gitPathFlag, "GIT_PATH", "")
+
+		gitSource, err := config.NewGitSource(repo, branch, dir, getPollInterval())
+		if err != nil {
+			applogger.Log.Fatalf("Failed to open git repository %s at %s: %v", repo, branch, err)
+		}
+		return gitSource
+	default:
+		applogger.Log.Fatalf("Unknown config source %q", kind)
+		return nil
+	}
+}
+
 var port = func() string {
 	if p := os.Getenv("PORT"); p != "" {
 		return ":" + p
@@ -72,20 +222,44 @@ var port = func() string {
 }()
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "promote":
+			os.Exit(runPromote(os.Args[2:]))
+		}
+	}
+
+	flag.Parse()
+
 	// Get configuration paths
 	configDir := getConfigDir()
 	allowedIPsFile := getAllowedIPsFile()
+	watchMode := getWatchMode()
+
+	// Set up tracing before anything is loaded, so that loads are traced
+	traceExporter := getSetting(*traceExporterFlag, "TRACE_EXPORTER", tracing.ExporterNone)
+	shutdownTracing, err := tracing.Setup(traceExporter, getSetting(*traceFileFlag, "TRACE_FILE", "traces.json"))
+	if err != nil {
+		applogger.Log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
-		AppName: "Simple Config Server",
+		AppName:      "Simple Config Server",
+		ErrorHandler: handler.ErrorHandler,
 	})
 
 	// Add middleware
+	app.Use(handler.RequestID)
+	app.Use(handler.RecordMetrics)
+	app.Use(handler.Trace)
 	app.Use(recover.New())
 	app.Use(cors.New())
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format: "[${time}] ${status} - ${latency} ${method} ${path}\n",
+		Format: "[${time}] ${respHeader:X-Request-ID} ${status} - ${latency} ${method} ${path}\n",
+		Next:   handler.IsProbe,
 	}))
 
 	// Setup directories and files
@@ -94,27 +268,87 @@ func main() {
 		scaffolding.Setup(configDir, allowedIPsFile)
 	}
 
-	// Load configurations and IP filters
+	// Load configurations, tenants and IP filters
+	source := getSource(configDir)
 	ipfilter.LoadAllowedIPs(allowedIPsFile)
-	config.LoadConfigs(configDir)
+	if tenantsFile := getSetting(*tenantsFileFlag, "TENANTS_FILE", ""); tenantsFile != "" {
+		if err := tenant.Load(tenantsFile); err != nil {
+			applogger.Log.Fatalf("Failed to load tenants: %v", err)
+		}
+	}
+	allowEnvPlaceholders()
+	config.LoadConfigs(source)
+	changeRequestsFile := getSetting(*changeRequestsFlag, "CHANGE_REQUESTS_FILE", filepath.Join(getWorkingDir(), "change_requests.json"))
+	if err := approval.Load(changeRequestsFile, getChangeRequestTTL()); err != nil {
+		applogger.Log.Fatalf("Failed to load change requests: %v", err)
+	}
+	schedulesFile := getSetting(*schedulesFlag, "SCHEDULES_FILE", filepath.Join(getWorkingDir(), "scheduled_changes.json"))
+	if err := schedule.Load(schedulesFile); err != nil {
+		applogger.Log.Fatalf("Failed to load scheduled changes: %v", err)
+	}
+
+	metrics.TrackLimiters(tenant.Default().Name, tenant.Default().Limiter.Len)
+	for _, t := range tenant.All() {
+		metrics.TrackLimiters(t.Name, t.Limiter.Len)
+	}
 
 	// Start watchers
-	go config.WatchConfigDir(configDir)
+	go config.WatchConfigs()
 	go ipfilter.WatchAllowedIPsFile(allowedIPsFile)
+	for _, t := range tenant.All() {
+		if t.HasOwnAllowlist() {
+			go t.Filter.Watch()
+		}
+	}
+	go handler.ExpireChangeRequests(time.Minute)
+	go handler.ApplyScheduledChanges(time.Second)
 
 	// Setup routes
-	app.Get("/*", handler.ConfigHandler)
+	adminToken := getSetting(*adminTokenFlag, "ADMIN_TOKEN", "")
+	handler.RegisterAll(app, getSetting(*metricsTokenFlag, "METRICS_TOKEN", ""), adminToken)
+
+	grpcPort := getSetting(*grpcPortFlag, "GRPC_PORT", "")
+	if grpcPort != "" {
+		go func() {
+			applogger.Log.Fatal(grpcserver.Serve(":" + grpcPort))
+		}()
+	}
 
 	// Log system startup
 	audit.LogSystem("STARTUP", "SUCCESS", map[string]interface{}{
 		"config_dir":       configDir,
+		"config_source":    source.Name(),
 		"allowed_ips_file": allowedIPsFile,
 		"port":             port,
+		"watch_mode":       watchMode,
+		"tenants":          len(tenant.All()),
+		"change_requests":  changeRequestsFile,
+		"schedules":        schedulesFile,
+		"grpc_port":        grpcPort,
+		"trace_exporter":   traceExporter,
+		"admin_api":        adminToken != "",
 	})
 
 	// Start server
 	applogger.Log.Printf("Starting server on %s", port)
 	applogger.Log.Printf("Using config directory: %s", configDir)
 	applogger.Log.Printf("Using allowed IPs file: %s", allowedIPsFile)
-	applogger.Log.Fatal(app.Listen(port))
+
+	// Stop on SIGINT or SIGTERM once pending spans are exported
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		applogger.Log.Printf("Shutting down")
+		if err := app.Shutdown(); err != nil {
+			applogger.Log.Printf("Failed to shut down: %v", err)
+		}
+	}()
+
+	if err := app.Listen(port); err != nil {
+		applogger.Log.Fatal(err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		applogger.Log.Printf("Failed to export pending spans: %v", err)
+	}
 }